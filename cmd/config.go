@@ -0,0 +1,215 @@
+/*
+Copyright © 2022 Árpád Csepi csepi.arpad@outlook.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// the sources of a flag value in precedence order
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+// ignoredWithTopology is added to the source of the cluster flags of the environment and the config file when a topology file is given
+const ignoredWithTopology = "(ignored, topology file is given)"
+
+// configValue is the effective value of a flag and where it come from
+type configValue struct {
+	Flag   string
+	Value  string
+	Source string
+}
+
+// skippedFlags are not configurable from the environment and the config file
+var skippedFlags = map[string]bool{"help": true, "config": true}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show the configuration of KLI",
+	Long:  "Config command is show how the flags of the commands are resolved from the command line, the KLI_* environment variables and the config file",
+}
+
+// configViewCmd represents the config view command
+var configViewCmd = &cobra.Command{
+	Use:   "view [command]",
+	Short: "Show the effective value of every flag and where it come from",
+	Long: `Config view command print the effective value of every flag of the install, uninstall, upgrade and status command (or only of the given command) and its source.
+The precedence is: flag > env > file > default. The environment variable of a flag is KLI_<COMMAND>_<FLAG> or KLI_<FLAG>, the config file key is <command>.<flag> or <flag>.`,
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{"install", "uninstall", "upgrade", "status"},
+	Run: func(_ *cobra.Command, args []string) {
+		commands := []*cobra.Command{installCmd, uninstallCmd, upgradeCmd, statusCmd}
+		if len(args) == 1 {
+			commands = filterCommands(commands, args[0])
+		}
+
+		configFile := viper.ConfigFileUsed()
+		if configFile == "" {
+			configFile = "none"
+		}
+		fmt.Printf("Config file: %s\n\n", configFile)
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		for _, command := range commands {
+			values, err := bindConfig(command)
+			checkErr(err)
+
+			fmt.Fprintf(writer, "Command %s\n\n", command.Name())
+			fmt.Fprintln(writer, "FLAG\tVALUE\tSOURCE")
+			for _, value := range values {
+				fmt.Fprintf(writer, "%s\t%s\t%s\n", value.Flag, value.Value, value.Source)
+			}
+			fmt.Fprintln(writer)
+		}
+		writer.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configViewCmd)
+}
+
+// filterCommands return the command with the given name, the usage error is printed when there is no such command
+func filterCommands(commands []*cobra.Command, name string) []*cobra.Command {
+	for _, command := range commands {
+		if command.Name() == name {
+			return []*cobra.Command{command}
+		}
+	}
+
+	checkErr(fmt.Errorf("unknown command %q, it must be install, uninstall, upgrade or status", name))
+	return nil
+}
+
+// bindConfig set every flag of the command which is not given on the command line from the environment or the config file
+// The set flags are changed like on the command line, so the mutually exclusive flag groups are checked again with the values of every source
+// The effective values are returned with their source in the order of the flag names
+func bindConfig(command *cobra.Command) ([]configValue, error) {
+	flags := []*pflag.Flag{}
+	command.Flags().VisitAll(func(flag *pflag.Flag) {
+		if !skippedFlags[flag.Name] {
+			flags = append(flags, flag)
+		}
+	})
+	// the topology flag is resolved first, the cluster flags of the environment and the config file are ignored when a topology file is given
+	sort.SliceStable(flags, func(i, j int) bool { return flags[i].Name == "topology" && flags[j].Name != "topology" })
+
+	values := []configValue{}
+	withTopology := false
+	for _, flag := range flags {
+		ignored := withTopology && clusterFlags[flag.Name]
+		source, err := resolveFlag(command.Flags(), command.Name(), flag, ignored)
+		if err != nil {
+			return nil, err
+		}
+		if flag.Name == "topology" {
+			withTopology = source != sourceDefault && flag.Value.String() != ""
+		}
+
+		// the commands share the flag variables, so the default is shown instead of the value which is set for an other command
+		value := flag.Value.String()
+		if source == sourceDefault || ignored && source != sourceFlag {
+			value = flag.DefValue
+		}
+		values = append(values, configValue{Flag: flag.Name, Value: value, Source: source})
+	}
+
+	err := command.ValidateFlagGroups()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errFlagConflict, err)
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i].Flag < values[j].Flag })
+	return values, nil
+}
+
+// resolveFlag set the flag from the first environment variable or config file key of the flag when it is not given on the command line
+// The source of the value is returned, e.g. "env KLI_TIMEOUT" or "file install.timeout", an ignored flag is not set, only its source is returned
+func resolveFlag(flags *pflag.FlagSet, command string, flag *pflag.Flag, ignored bool) (string, error) {
+	if flag.Changed {
+		return sourceFlag, nil
+	}
+
+	name := strings.ToUpper(strings.ReplaceAll(flag.Name, "-", "_"))
+	for _, env := range []string{"KLI_" + strings.ToUpper(command) + "_" + name, "KLI_" + name} {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		if ignored {
+			return sourceEnv + " " + env + " " + ignoredWithTopology, nil
+		}
+
+		err := flags.Set(flag.Name, value)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s environment variable: %w", env, err)
+		}
+		return sourceEnv + " " + env, nil
+	}
+
+	for _, key := range []string{command + "." + flag.Name, flag.Name} {
+		if !viper.InConfig(key) {
+			continue
+		}
+		if ignored {
+			return sourceFile + " " + key + " " + ignoredWithTopology, nil
+		}
+
+		err := setFromConfig(flags, flag, viper.Get(key))
+		if err != nil {
+			return "", fmt.Errorf("invalid %s key in %s: %w", key, viper.ConfigFileUsed(), err)
+		}
+		return sourceFile + " " + key, nil
+	}
+
+	return sourceDefault, nil
+}
+
+// setFromConfig set the flag from a config file value, a list set every item of a repeatable flag and a map set the key=value pairs
+func setFromConfig(flags *pflag.FlagSet, flag *pflag.Flag, value interface{}) error {
+	switch typed := value.(type) {
+	case []interface{}:
+		sliceValue, ok := flag.Value.(pflag.SliceValue)
+		if !ok {
+			return fmt.Errorf("the %s flag is not a list", flag.Name)
+		}
+		if len(typed) == 0 {
+			return sliceValue.Replace([]string{})
+		}
+
+		items := []string{}
+		for _, item := range typed {
+			items = append(items, fmt.Sprint(item))
+		}
+		// the first item mark the flag changed, then the items are set together, so an item can contain comma
+		err := flags.Set(flag.Name, items[0])
+		if err != nil {
+			return err
+		}
+		return sliceValue.Replace(items)
+	case map[string]interface{}:
+		pairs := []string{}
+		for key, item := range typed {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", key, item))
+		}
+		sort.Strings(pairs)
+		return flags.Set(flag.Name, strings.Join(pairs, ","))
+	}
+
+	return flags.Set(flag.Name, fmt.Sprint(value))
+}
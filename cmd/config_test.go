@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// testConfig has a command and a global key for the context flag and list and map values for the install flags
+var testConfig = `
+context: file-context
+install:
+  context: file-install-context
+  values:
+    - cluster-registry=registry-values.yaml
+    - banzaicloud-stable=operator-values.yaml
+  version:
+    cluster-registry: 0.2.11
+    banzaicloud-stable: ~2.17.0
+`
+
+// newConfigTestCommand return an install command with its own flag variables
+func newConfigTestCommand() *cobra.Command {
+	command := &cobra.Command{Use: "install"}
+	command.Flags().String("context", "default-context", "")
+	command.Flags().Duration("timeout", time.Minute, "")
+	command.Flags().StringArrayP("values", "f", []string{}, "")
+	command.Flags().StringToString("version", map[string]string{}, "")
+	command.Flags().Bool("reuse-values", false, "")
+	command.Flags().Bool("reset-values", false, "")
+	command.MarkFlagsMutuallyExclusive("reuse-values", "reset-values")
+	command.Flags().String("topology", "", "")
+	command.Flags().String("main-context", "", "")
+	command.MarkFlagsMutuallyExclusive("topology", "main-context")
+
+	return command
+}
+
+func readTestConfig(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.SetConfigFile(path)
+	err = viper.ReadInConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(viper.Reset)
+}
+
+func TestBindConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		config string
+		flag   string
+		value  string
+		source string
+	}{
+		{
+			name:   "flag",
+			args:   []string{"--context", "flag-context"},
+			env:    map[string]string{"KLI_INSTALL_CONTEXT": "env-install-context", "KLI_CONTEXT": "env-context"},
+			config: testConfig,
+			flag:   "context",
+			value:  "flag-context",
+			source: "flag",
+		},
+		{
+			name:   "command env",
+			env:    map[string]string{"KLI_INSTALL_CONTEXT": "env-install-context", "KLI_CONTEXT": "env-context"},
+			config: testConfig,
+			flag:   "context",
+			value:  "env-install-context",
+			source: "env KLI_INSTALL_CONTEXT",
+		},
+		{
+			name:   "env",
+			env:    map[string]string{"KLI_CONTEXT": "env-context"},
+			config: testConfig,
+			flag:   "context",
+			value:  "env-context",
+			source: "env KLI_CONTEXT",
+		},
+		{
+			name:   "command file key",
+			config: testConfig,
+			flag:   "context",
+			value:  "file-install-context",
+			source: "file install.context",
+		},
+		{
+			name:   "file key",
+			config: "context: file-context\n",
+			flag:   "context",
+			value:  "file-context",
+			source: "file context",
+		},
+		{
+			name:   "default",
+			config: testConfig,
+			flag:   "timeout",
+			value:  "1m0s",
+			source: "default",
+		},
+		{
+			name:   "file list",
+			config: testConfig,
+			flag:   "values",
+			value:  "[cluster-registry=registry-values.yaml,banzaicloud-stable=operator-values.yaml]",
+			source: "file install.values",
+		},
+		{
+			name:   "env list",
+			env:    map[string]string{"KLI_INSTALL_VALUES": "cluster-registry=env-values.yaml"},
+			config: testConfig,
+			flag:   "values",
+			value:  "[cluster-registry=env-values.yaml]",
+			source: "env KLI_INSTALL_VALUES",
+		},
+		{
+			name:   "file map",
+			config: testConfig,
+			flag:   "version",
+			value:  "map[banzaicloud-stable:~2.17.0 cluster-registry:0.2.11]",
+			source: "file install.version",
+		},
+		{
+			name:   "flag map",
+			args:   []string{"--version", "cluster-registry=0.2.12"},
+			config: testConfig,
+			flag:   "version",
+			value:  "map[cluster-registry:0.2.12]",
+			source: "flag",
+		},
+		{
+			name:   "cluster flag with topology",
+			args:   []string{"--topology", "topology.yaml"},
+			config: "main-context: kind-kind\n",
+			flag:   "main-context",
+			value:  "",
+			source: "file main-context (ignored, topology file is given)",
+		},
+		{
+			name:   "cluster flag with topology from env",
+			env:    map[string]string{"KLI_TOPOLOGY": "topology.yaml", "KLI_MAIN_CONTEXT": "kind-kind"},
+			flag:   "main-context",
+			value:  "",
+			source: "env KLI_MAIN_CONTEXT (ignored, topology file is given)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for env, value := range test.env {
+				t.Setenv(env, value)
+			}
+			readTestConfig(t, test.config)
+
+			command := newConfigTestCommand()
+			err := command.ParseFlags(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			values, err := bindConfig(command)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			source := ""
+			for _, value := range values {
+				if value.Flag == test.flag {
+					source = value.Source
+				}
+			}
+			if source != test.source {
+				t.Errorf("Expected %s source, got %s", test.source, source)
+			}
+
+			// the map flag print its pairs in random order, so the map is compared
+			actual := command.Flags().Lookup(test.flag).Value.String()
+			if test.flag == "version" {
+				versions, _ := command.Flags().GetStringToString("version")
+				actual = fmt.Sprint(versions)
+			}
+			if actual != test.value {
+				t.Errorf("Expected %s value, got %s", test.value, actual)
+			}
+		})
+	}
+}
+
+func TestBindConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		config string
+		err    string
+	}{
+		{name: "env", env: map[string]string{"KLI_TIMEOUT": "soon"}, err: "invalid KLI_TIMEOUT environment variable"},
+		{name: "file", config: "install:\n  timeout: soon\n", err: "invalid install.timeout key"},
+		{name: "not a list", config: "context:\n  - a\n  - b\n", err: "the context flag is not a list"},
+		{name: "exclusive file and flag", args: []string{"--reset-values"}, config: "reuse-values: true\n", err: "[reset-values reuse-values] were all set"},
+		{name: "exclusive env and file", env: map[string]string{"KLI_INSTALL_RESET_VALUES": "true"}, config: "reuse-values: true\n", err: "[reset-values reuse-values] were all set"},
+		{name: "exclusive flags with topology", args: []string{"--topology", "topology.yaml", "--main-context", "kind-kind"}, err: "[main-context topology] were all set"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for env, value := range test.env {
+				t.Setenv(env, value)
+			}
+			readTestConfig(t, test.config)
+
+			command := newConfigTestCommand()
+			err := command.ParseFlags(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = bindConfig(command)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Expected %q error, got %v", test.err, err)
+			}
+			// the conflicting flags are usage errors like on the command line
+			if strings.HasPrefix(test.name, "exclusive") {
+				if code, _ := exitCode(err); code != exitUsage {
+					t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+				}
+			}
+		})
+	}
+}
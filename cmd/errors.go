@@ -0,0 +1,80 @@
+/*
+Copyright © 2022 Árpád Csepi csepi.arpad@outlook.com
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/arpad-csepi/KLI/kubereflex"
+)
+
+// Exit codes of KLI, so the scripts can react to the reason of the failure
+const (
+	exitError              = 1
+	exitUsage              = 2
+	exitContextNotFound    = 3
+	exitReleaseExists      = 4
+	exitApplyConflict      = 5
+	exitDeploymentNotReady = 6
+	exitMeshFailed         = 7
+	exitStuckTerminating   = 8
+)
+
+// errFlagConflict is returned when the flags from the command line, the environment and the config file can not be used together
+var errFlagConflict = errors.New("conflicting flags from the command line, KLI_* environment variables or config file")
+
+// failure is a known kubereflex error with its exit code and a hint how to fix it
+type failure struct {
+	err  error
+	code int
+	hint string
+}
+
+var failures = []failure{
+	{err: kubereflex.ErrContextNotFound, code: exitContextNotFound, hint: "Check the context names with 'kubectl config get-contexts' or set them with --main-context, --secondary-context or the topology file"},
+	{err: errFlagConflict, code: exitUsage, hint: "Check where the flags come from with 'KLI config view', a config file or environment value count as a given flag"},
+	{err: kubereflex.ErrNonInteractive, code: exitUsage, hint: "Set the contexts with --main-context, --secondary-context or the topology file, the prompt is disabled with --non-interactive or without terminal"},
+	{err: kubereflex.ErrReleaseExists, code: exitReleaseExists, hint: "The release is already installed, use 'KLI upgrade' to change it or 'KLI uninstall' to remove it first"},
+	{err: kubereflex.ErrApplyConflict, code: exitApplyConflict, hint: "An other field manager owns fields of the custom resource, use --force-conflicts to take them over"},
+	{err: kubereflex.ErrStuckTerminating, code: exitStuckTerminating, hint: "Check the finalizers of the objects and the controllers which should remove them, or increase the --timeout"},
+	{err: kubereflex.ErrDeploymentNotReady, code: exitDeploymentNotReady, hint: "Increase the --timeout or check the workloads of the release with 'kubectl get pods,jobs,crds'"},
+}
+
+// exitCode return the exit code and the hint which belong to the error
+func exitCode(err error) (int, string) {
+	var meshError *kubereflex.MeshError
+	if errors.As(err, &meshError) {
+		return exitMeshFailed, "Check the cluster-registry Cluster and Secret objects with 'KLI status'"
+	}
+
+	for _, f := range failures {
+		if errors.Is(err, f.err) {
+			return f.code, f.hint
+		}
+	}
+
+	return exitError, ""
+}
+
+// checkErr print the error without stack trace and exit with the exit code of the error, nothing happens when err is nil
+func checkErr(err error) {
+	if err == nil {
+		return
+	}
+
+	code, hint := exitCode(err)
+
+	if jsonOutput() {
+		emit(event{Step: commandName, Status: statusFailed, Error: err.Error(), ExitCode: code})
+	}
+
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	if hint != "" {
+		fmt.Fprintln(os.Stderr, "Hint:", hint)
+	}
+
+	os.Exit(code)
+}
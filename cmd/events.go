@@ -0,0 +1,124 @@
+/*
+Copyright © 2022 Árpád Csepi csepi.arpad@outlook.com
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/arpad-csepi/KLI/kubereflex"
+	"github.com/spf13/cobra"
+)
+
+// Statuses of the events
+const (
+	statusPlanned        = "planned"
+	statusStarted        = "started"
+	statusSucceeded      = "succeeded"
+	statusFailed         = "failed"
+	statusRolledBack     = "rolled-back"
+	statusRollbackFailed = "rollback-failed"
+)
+
+// event is one line of the JSON output, every step emit a started event and a succeeded or failed event
+type event struct {
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command"`
+	Step     string    `json:"step"`
+	Cluster  string    `json:"cluster,omitempty"`
+	Context  string    `json:"context,omitempty"`
+	Resource string    `json:"resource,omitempty"`
+	Status   string    `json:"status"`
+	// Duration of the step in seconds
+	Duration float64  `json:"duration,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	ExitCode int      `json:"exitCode,omitempty"`
+	Details  []string `json:"details,omitempty"`
+}
+
+var eventOutput string
+
+// outputLock keep the events and messages of the steps which run at the same time in one piece
+var outputLock sync.Mutex
+
+// commandName is the name of the running command, it is the command field of the events
+var commandName string
+
+// addOutputFlag register the --output flag of the commands which emit events
+func addOutputFlag(command *cobra.Command) {
+	command.Flags().StringVarP(&eventOutput, "output", "o", "text", "Output format: text or json (a stream of JSON events, one per line)")
+
+	command.PreRun = func(cmd *cobra.Command, _ []string) {
+		commandName = cmd.Name()
+
+		if eventOutput != "text" && eventOutput != "json" {
+			checkErr(fmt.Errorf("unknown output format %q, use text or json", eventOutput))
+		}
+
+		// the progress messages of the library would break the JSON stream, so they go to the standard error
+		if jsonOutput() {
+			kubereflex.SetOutput(os.Stderr)
+		}
+	}
+}
+
+// jsonOutput tell the events are written as JSON instead of text
+func jsonOutput() bool {
+	return eventOutput == "json"
+}
+
+// documentOutput tell the standard output is a document which can be parsed: the JSON event stream or the JSON or YAML status report
+func documentOutput() bool {
+	return jsonOutput() || output == "json" || output == "yaml"
+}
+
+// messageOutput return the writer of the human readable messages, it is the standard error when the standard output is a document
+func messageOutput() io.Writer {
+	if documentOutput() {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+// event return the event of the step with the given status
+func (s step) event(status string, duration time.Duration, err error) event {
+	e := event{
+		Step:     s.action,
+		Cluster:  s.cluster,
+		Context:  s.context,
+		Resource: s.object,
+		Status:   status,
+		Duration: duration.Seconds(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if status == statusPlanned {
+		e.Details = s.details
+	}
+
+	return e
+}
+
+// emit write the event as one JSON line to the standard output
+func emit(e event) {
+	e.Time = time.Now()
+	e.Command = commandName
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+
+	outputLock.Lock()
+	defer outputLock.Unlock()
+
+	fmt.Println(string(data))
+}
@@ -4,17 +4,22 @@ Copyright © 2022 Árpád Csepi csepi.arpad@outlook.com
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/arpad-csepi/KLI/kubereflex"
+	"github.com/arpad-csepi/KLI/kubereflex/kubectl"
+	"github.com/arpad-csepi/KLI/kubereflex/topology"
 
 	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/cli/values"
 
-	"flag"
-	"path/filepath"
+	"os"
 	"time"
 
-	"k8s.io/client-go/util/homedir"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 type chartData struct {
@@ -24,7 +29,8 @@ type chartData struct {
 	releaseName    string
 	namespace      string
 	arguments      map[string]string
-	deploymentName string
+	// valueLevels are the helm values of the topology chart, the topology cluster and the flags, the later level win
+	valueLevels []values.Options
 }
 
 // installCmd represents the install command
@@ -33,154 +39,226 @@ var installCmd = &cobra.Command{
 	Short: "Install istio-operator and cluster-registry-controller",
 	Long:  `Install command is create charts, install with helm package manager and configure depends on other parameters`,
 	Run: func(_ *cobra.Command, _ []string) {
-		if mainClusterConfigPath == "" {
-			mainClusterConfigPath = *getKubeConfig()
-		}
+		installPlan := buildInstallPlan(getTopology())
 
-		if mainContext == "" {
-			fmt.Println("Main cluster context switcher:")
-			mainContext = kubereflex.ChooseContextFromConfig(&mainClusterConfigPath)
+		if dryRun {
+			installPlan.print()
+			return
 		}
 
-		clusterRegistry1 := chartData{
-			chartUrl:       "https://cisco-open.github.io/cluster-registry-controller",
-			repositoryName: "cluster-registry",
-			chartName:      "cluster-registry",
-			releaseName:    "cluster-registry",
-			namespace:      "cluster-registry",
-			arguments:      map[string]string{"set": "localCluster.name=demo-active,network.name=network1,controller.apiServerEndpointAddress=" + kubereflex.GetAPIServerEndpoint(&mainClusterConfigPath, mainContext)},
-		}
+		installPlan.execute()
+	},
+}
 
-		kubereflex.InstallHelmChart(clusterRegistry1.chartUrl,
-			clusterRegistry1.repositoryName,
-			clusterRegistry1.chartName,
-			clusterRegistry1.releaseName,
-			clusterRegistry1.namespace,
-			clusterRegistry1.arguments,
-			&mainClusterConfigPath,
-			mainContext)
-
-		clusterRegistry1.deploymentName = kubereflex.GetDeploymentName(clusterRegistry1.releaseName,
-			clusterRegistry1.namespace,
-			&mainClusterConfigPath,
-			mainContext)
-
-		if verify {
-			kubereflex.Verify(clusterRegistry1.deploymentName,
-				clusterRegistry1.namespace,
-				&mainClusterConfigPath,
-				mainContext,
-				time.Duration(timeout)*time.Second)
-		}
+// getAPIServerEndpoints return the API server endpoint of every cluster by cluster name
+func getAPIServerEndpoints(clusterTopology *topology.Topology) map[string]string {
+	apiServerEndpoints := map[string]string{}
+	for i := range clusterTopology.Clusters {
+		cluster := &clusterTopology.Clusters[i]
+		endpoint, err := kubereflex.GetAPIServerEndpoint(&cluster.Kubeconfig, cluster.Context)
+		checkErr(err)
+		apiServerEndpoints[cluster.Name] = endpoint
+	}
 
-		if secondaryClusterConfigPath == "" {
-			secondaryClusterConfigPath = mainClusterConfigPath
-		}
-		if secondaryClusterConfigPath != "" {
-			if secondaryContext == "" {
-				fmt.Println("Secondary cluster context switcher:")
-				secondaryContext = kubereflex.ChooseContextFromConfig(&secondaryClusterConfigPath)
-			}
+	return apiServerEndpoints
+}
 
-			clusterRegistry2 := chartData{
-				chartUrl:       "https://cisco-open.github.io/cluster-registry-controller",
-				repositoryName: "cluster-registry",
-				chartName:      "cluster-registry",
-				releaseName:    "cluster-registry",
-				namespace:      "cluster-registry",
-				arguments:      map[string]string{"set": "localCluster.name=demo-passive,network.name=network2,controller.apiServerEndpointAddress=" + kubereflex.GetAPIServerEndpoint(&secondaryClusterConfigPath, secondaryContext)},
-			}
+// newChartData build the chart data of the topology chart for the cluster with the rendered values, the version and value flags
+func newChartData(chart topology.Chart, cluster *topology.Cluster, apiServerEndpoint string) *chartData {
+	arguments, valueLevels, err := chart.ArgumentsFor(cluster, apiServerEndpoint)
+	checkErr(err)
+
+	applyVersionFlags(chart.Release, arguments)
+	// the repositories are updated once by the repositories step before the charts
+	arguments["skip-update"] = "true"
+
+	return &chartData{
+		chartUrl:       chart.URL,
+		repositoryName: chart.Repository,
+		chartName:      chart.Name,
+		releaseName:    chart.Release,
+		namespace:      chart.Namespace,
+		arguments:      arguments,
+		valueLevels:    append(valueLevels, flagValues(chart.Release)),
+	}
+}
 
-			kubereflex.InstallHelmChart(clusterRegistry2.chartUrl,
-				clusterRegistry2.repositoryName,
-				clusterRegistry2.chartName,
-				clusterRegistry2.releaseName,
-				clusterRegistry2.namespace,
-				clusterRegistry2.arguments,
-				&secondaryClusterConfigPath,
-				secondaryContext)
+// chartLabel return the chart name with the requested version or constraint when it is set
+func (c *chartData) chartLabel() string {
+	if c.arguments["version"] == "" {
+		return fmt.Sprintf("%s/%s", c.repositoryName, c.chartName)
+	}
 
-			clusterRegistry2.deploymentName = kubereflex.GetDeploymentName(clusterRegistry2.releaseName,
-				clusterRegistry2.namespace,
-				&secondaryClusterConfigPath,
-				secondaryContext)
+	return fmt.Sprintf("%s/%s %s", c.repositoryName, c.chartName, c.arguments["version"])
+}
 
-			if verify {
-				kubereflex.Verify(clusterRegistry2.deploymentName,
-					clusterRegistry2.namespace,
-					&secondaryClusterConfigPath,
-					secondaryContext,
-					time.Duration(timeout)*time.Second)
-			}
+// repositoriesStep build the step which add and update the helm repositories of the charts once
+// The charts are installed or upgraded on the clusters at the same time after it, and they only read the repository index
+func repositoriesStep(clusterTopology *topology.Topology) step {
+	repositories := map[string]string{}
+	names := []string{}
+	for _, chart := range clusterTopology.Charts {
+		if _, exists := repositories[chart.Repository]; !exists {
+			names = append(names, chart.Repository)
 		}
+		repositories[chart.Repository] = chart.URL
+	}
 
-		istioOperator := chartData{
-			chartUrl:       "https://kubernetes-charts.banzaicloud.com",
-			repositoryName: "banzaicloud-stable",
-			chartName:      "istio-operator",
-			releaseName:    "banzaicloud-stable",
-			namespace:      "istio-system",
-			arguments:      map[string]string{"set": "clusterRegistry.clusterAPI.enabled=true,clusterRegistry.resourceSyncRules.enabled=true"},
-		}
+	return step{
+		action:  "update",
+		object:  "helm repositories " + strings.Join(names, ", "),
+		cluster: localMachine,
+		run: func() error {
+			return kubereflex.PrepareHelmRepositories(repositories)
+		},
+	}
+}
 
-		kubereflex.InstallHelmChart(istioOperator.chartUrl,
-			istioOperator.repositoryName,
-			istioOperator.chartName,
-			istioOperator.releaseName,
-			istioOperator.namespace,
-			istioOperator.arguments,
-			&mainClusterConfigPath,
-			mainContext)
-
-		istioOperator.deploymentName = kubereflex.GetDeploymentName(istioOperator.releaseName,
-			istioOperator.namespace,
-			&mainClusterConfigPath,
-			mainContext)
-
-		if verify {
-			kubereflex.Verify(istioOperator.deploymentName,
-				istioOperator.namespace,
-				&mainClusterConfigPath,
-				mainContext,
+// verifyStep build the step which verify every workload of the chart release on the cluster
+func verifyStep(installChart *chartData, cluster *topology.Cluster) step {
+	return step{
+		action:     "verify",
+		object:     fmt.Sprintf("workloads of helm release %s/%s", installChart.namespace, installChart.releaseName),
+		cluster:    cluster.Name,
+		kubeconfig: &cluster.Kubeconfig,
+		context:    cluster.Context,
+		run: func() error {
+			return kubereflex.Verify(installChart.releaseName,
+				installChart.namespace,
+				&cluster.Kubeconfig,
+				cluster.Context,
 				time.Duration(timeout)*time.Second)
-		}
+		},
+	}
+}
 
-		if activeCRDPath != "" {
-			kubereflex.Apply(activeCRDPath, &mainClusterConfigPath, mainContext)
-		}
+// buildInstallPlan collect the install steps in order, in dry run mode the charts are rendered to show their resources
+func buildInstallPlan(clusterTopology *topology.Topology) *plan {
+	installPlan := &plan{name: "Install"}
+
+	checkReleaseFlags(clusterTopology)
+
+	apiServerEndpoints := getAPIServerEndpoints(clusterTopology)
+
+	installPlan.add(repositoriesStep(clusterTopology))
+
+	for _, chart := range clusterTopology.Charts {
+		for i := range clusterTopology.Clusters {
+			cluster := &clusterTopology.Clusters[i]
+
+			installChart := newChartData(chart, cluster, apiServerEndpoints[cluster.Name])
+			// started tell the install reached the cluster, so a release can be left there even when the install failed
+			started := false
+
+			installStep := step{
+				action:     "install",
+				object:     fmt.Sprintf("helm release %s/%s (chart %s)", installChart.namespace, installChart.releaseName, installChart.chartLabel()),
+				cluster:    cluster.Name,
+				kubeconfig: &cluster.Kubeconfig,
+				context:    cluster.Context,
+				run: func() error {
+					_, err := kubereflex.InstallHelmChart(installChart.chartUrl,
+						installChart.repositoryName,
+						installChart.chartName,
+						installChart.releaseName,
+						installChart.namespace,
+						installChart.arguments,
+						installChart.valueLevels,
+						&cluster.Kubeconfig,
+						cluster.Context)
+					// the release which was already installed is not touched by the rollback
+					started = !errors.Is(err, kubereflex.ErrReleaseExists)
+					return err
+				},
+				undo: func() error {
+					if !started {
+						return nil
+					}
+					return kubereflex.UninstallHelmChart(installChart.releaseName, installChart.namespace, &cluster.Kubeconfig, cluster.Context)
+				},
+				undoFailed: true,
+			}
 
-		if secondaryClusterConfigPath != "" {
-			kubereflex.InstallHelmChart(istioOperator.chartUrl,
-				istioOperator.repositoryName,
-				istioOperator.chartName,
-				istioOperator.releaseName,
-				istioOperator.namespace,
-				istioOperator.arguments,
-				&secondaryClusterConfigPath,
-				secondaryContext)
-
-			istioOperator.deploymentName = kubereflex.GetDeploymentName(istioOperator.releaseName,
-				istioOperator.namespace,
-				&secondaryClusterConfigPath,
-				secondaryContext)
+			if dryRun {
+				helmRelease, resources, err := kubereflex.RenderHelmChart(installChart.chartUrl,
+					installChart.repositoryName,
+					installChart.chartName,
+					installChart.releaseName,
+					installChart.namespace,
+					installChart.arguments,
+					installChart.valueLevels,
+					&cluster.Kubeconfig,
+					cluster.Context)
+				checkErr(err)
+
+				installStep.object = fmt.Sprintf("helm release %s/%s (chart %s/%s %s)", installChart.namespace, installChart.releaseName, installChart.repositoryName, installChart.chartName, helmRelease.Chart.Metadata.Version)
+				if installChart.arguments["version"] != "" {
+					installStep.details = append(installStep.details, "requested version "+installChart.arguments["version"])
+				}
+				installStep.details = append(installStep.details, resources...)
+			}
+
+			installPlan.add(installStep)
 
 			if verify {
-				kubereflex.Verify(istioOperator.deploymentName,
-					istioOperator.namespace,
-					&secondaryClusterConfigPath,
-					secondaryContext,
-					time.Duration(timeout)*time.Second)
+				installPlan.add(verifyStep(installChart, cluster))
 			}
 		}
+	}
 
-		if passiveCRDPath != "" {
-			kubereflex.Apply(passiveCRDPath, &secondaryClusterConfigPath, secondaryContext)
+	for i := range clusterTopology.Clusters {
+		cluster := &clusterTopology.Clusters[i]
+
+		if cluster.CustomResource != "" {
+			var created []*unstructured.Unstructured
+
+			installPlan.add(step{
+				action:     "apply",
+				object:     "custom resource " + cluster.CustomResource,
+				cluster:    cluster.Name,
+				kubeconfig: &cluster.Kubeconfig,
+				context:    cluster.Context,
+				details:    resourceNames(cluster.CustomResource),
+				run: func() error {
+					applied, err := kubereflex.Apply(cluster.CustomResource, forceConflicts, &cluster.Kubeconfig, cluster.Context)
+					for _, resource := range applied {
+						if resource.Result == kubectl.ApplyCreated {
+							created = append(created, resource.Object)
+						}
+					}
+					return err
+				},
+				undo: func() error {
+					// the objects which were already on the cluster are kept by the rollback
+					if len(created) == 0 {
+						return nil
+					}
+					return kubereflex.RemoveObjects(created, kubectl.RemoveOptions{}, &cluster.Kubeconfig, cluster.Context)
+				},
+			})
 		}
+	}
 
-		if attach {
-			kubereflex.Attach(&mainClusterConfigPath, mainContext, &secondaryClusterConfigPath, secondaryContext, "cluster-registry", "cluster-registry")
-		}
-	},
+	if (attach || clusterTopology.Attach) && len(clusterTopology.Clusters) > 1 {
+		members := meshMembers(clusterTopology)
+
+		installPlan.add(step{
+			action:  "attach",
+			object:  "cluster-registry peers",
+			cluster: everyCluster,
+			details: meshObjects(members),
+			run: func() error {
+				_, err := kubereflex.Attach(members...)
+				return err
+			},
+			undo: func() error {
+				_, err := kubereflex.Detach(members...)
+				return err
+			},
+		})
+	}
+
+	return installPlan
 }
 
 var verify bool
@@ -196,30 +274,38 @@ var activeCRDPath string
 var passiveCRDPath string
 
 var attach bool
+var forceConflicts bool
 
 func init() {
 	rootCmd.AddCommand(installCmd)
 
-	installCmd.Flags().BoolVarP(&attach, "attach", "a", false, "Connect two cluster together")
+	installCmd.Flags().BoolVarP(&attach, "attach", "a", false, "Connect every cluster with every other cluster")
 	installCmd.Flags().StringVarP(&activeCRDPath, "active-custom-resource", "r", "", "Specify custom resource file location for the active cluster")
 	installCmd.Flags().StringVarP(&passiveCRDPath, "passive-custom-resource", "R", "", "Specify custom resource file location for the passive cluster")
+	installCmd.Flags().BoolVar(&forceConflicts, "force-conflicts", false, "Take over the fields of the custom resources which are owned by an other field manager")
 	installCmd.Flags().BoolVarP(&verify, "verify", "v", false, "Verify the deployment is ready or not")
 	installCmd.Flags().IntVarP(&timeout, "timeout", "t", 60, "Set verify timeout in seconds")
 	installCmd.Flags().StringVarP(&mainClusterConfigPath, "main-cluster", "c", "", "Main cluster kubeconfig file location")
 	installCmd.Flags().StringVarP(&secondaryClusterConfigPath, "secondary-cluster", "C", "", "Secondary cluster kubeconfig file location")
 	installCmd.Flags().StringVarP(&mainContext, "main-context", "k", "", "Main cluster context name")
 	installCmd.Flags().StringVarP(&secondaryContext, "secondary-context", "K", "", "Secondary cluster context name")
+	addVersionFlags(installCmd)
+	addValueFlags(installCmd)
+	installCmd.Flags().BoolVar(&noRollback, "no-rollback", false, "Keep the completed steps on the clusters when the install fails")
+	addParallelFlag(installCmd)
+	installCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the install plan with the rendered charts without changing the clusters")
+	addOutputFlag(installCmd)
+	addClusterNameFlags(installCmd)
+	addTopologyFlag(installCmd)
+	addSingleClusterFlag(installCmd)
 }
 
-// getKubeConfig is try to find default kube config in some default paths
+// getKubeConfig return the KUBECONFIG environment variable (a path list is merged) or the default kube config path
 func getKubeConfig() *string {
-	var kubeconfig *string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+	kubeconfig := os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
+	if kubeconfig == "" {
+		kubeconfig = clientcmd.RecommendedHomeFile
 	}
-	flag.Parse()
 
-	return kubeconfig
+	return &kubeconfig
 }
@@ -0,0 +1,346 @@
+/*
+Copyright © 2022 Árpád Csepi csepi.arpad@outlook.com
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arpad-csepi/KLI/kubereflex"
+	"github.com/spf13/cobra"
+)
+
+// everyCluster is the cluster of the steps which work on every cluster at once
+const everyCluster = "every cluster"
+
+// localMachine is the cluster of the steps which change only the local machine, e.g. the helm repositories
+const localMachine = "local machine"
+
+// step is one operation of the install or uninstall plan
+type step struct {
+	action  string
+	object  string
+	cluster string
+	// kubeconfig and context of the cluster, empty when the step works on every cluster
+	// The kubeconfig is the field of the topology cluster, so the output of the cluster is separated even when more cluster use the same context
+	kubeconfig *string
+	context    string
+	details    []string
+	run        func() error
+	// undo revert the step on rollback, nil when the step has nothing to revert
+	undo func() error
+	// undoFailed tell the undo is called on rollback also when the step itself failed, because it can leave a partial change, e.g. a failed helm release
+	undoFailed bool
+}
+
+// plan is the ordered list of steps which are performed by install or uninstall
+type plan struct {
+	name  string
+	steps []step
+}
+
+var dryRun bool
+var noRollback bool
+
+// parallel is the maximum number of clusters which are changed at the same time, 0 means every cluster
+var parallel int
+
+// addParallelFlag register the --parallel flag of the commands which execute a plan
+func addParallelFlag(command *cobra.Command) {
+	command.Flags().IntVarP(&parallel, "parallel", "p", 0, "Maximum number of clusters which are changed at the same time, 0 means every cluster and 1 run the steps one by one")
+}
+
+func (p *plan) add(s step) {
+	p.steps = append(p.steps, s)
+}
+
+// where return the cluster name with its context for the text output
+func (s step) where() string {
+	if s.context == "" {
+		return s.cluster
+	}
+
+	return fmt.Sprintf("%s (context %s)", s.cluster, s.context)
+}
+
+// print write every step of the plan to the standard output without running them
+func (p *plan) print() {
+	if jsonOutput() {
+		for _, s := range p.steps {
+			emit(s.event(statusPlanned, 0, nil))
+		}
+		return
+	}
+
+	fmt.Printf("%s plan (dry run, the clusters are not changed):\n", p.name)
+	for i, s := range p.steps {
+		fmt.Printf("%3d. %s %s on %s\n", i+1, s.action, s.object, s.where())
+		for _, detail := range s.details {
+			fmt.Printf("       %s\n", detail)
+		}
+	}
+
+	switch {
+	case parallel == 0:
+		fmt.Println("The steps of different clusters run at the same time, a step on every cluster waits for the steps before it")
+	case parallel > 1:
+		fmt.Printf("The steps of at most %d clusters run at the same time, a step on every cluster waits for the steps before it\n", parallel)
+	}
+}
+
+// alone tell the step is not on a single cluster, so it has its own stage and wait for every step before it
+func (s step) alone() bool {
+	return s.cluster == everyCluster || s.cluster == localMachine
+}
+
+// stages split the steps into stages which run in order, a stage is one step on every cluster (or on the local machine) or the steps on single clusters between them
+func (p *plan) stages() [][]step {
+	stages := [][]step{}
+	current := []step{}
+
+	for _, s := range p.steps {
+		if !s.alone() {
+			current = append(current, s)
+			continue
+		}
+
+		if len(current) != 0 {
+			stages = append(stages, current)
+			current = []step{}
+		}
+		stages = append(stages, []step{s})
+	}
+
+	if len(current) != 0 {
+		stages = append(stages, current)
+	}
+
+	return stages
+}
+
+// chains group the steps of the stage by cluster, the steps of one cluster keep their order in the plan
+func chains(stage []step) [][]step {
+	clusterChains := [][]step{}
+	index := map[string]int{}
+
+	for _, s := range stage {
+		i, ok := index[s.cluster]
+		if !ok {
+			i = len(clusterChains)
+			index[s.cluster] = i
+			clusterChains = append(clusterChains, []step{})
+		}
+		clusterChains[i] = append(clusterChains[i], s)
+	}
+
+	return clusterChains
+}
+
+// execution is the state of the running plan which is shared by the steps of the clusters
+type execution struct {
+	lock sync.Mutex
+	// completed are the steps which are undone on rollback: the finished steps and the failed steps with undoFailed
+	completed []step
+	errs      []error
+}
+
+// execute run the plan and exit with the error of the failed steps, see perform
+func (p *plan) execute() {
+	planStart := time.Now()
+
+	checkErr(p.perform())
+
+	if jsonOutput() {
+		emit(event{Step: commandName, Status: statusSucceeded, Duration: time.Since(planStart).Seconds()})
+	}
+}
+
+// perform run the stages of the plan in order, inside a stage the steps of different clusters run at the same time up to --parallel
+// When a step fails no new step is started and the completed steps are undone in reverse order, the errors of the failed steps are returned
+func (p *plan) perform() error {
+	if parallel < 0 {
+		return fmt.Errorf("invalid --parallel %d, it must be 0 or more", parallel)
+	}
+
+	run := &execution{}
+	for _, stage := range p.stages() {
+		run.stage(stage)
+		if run.failed() {
+			break
+		}
+	}
+
+	if !run.failed() {
+		return nil
+	}
+
+	if noRollback {
+		if !jsonOutput() {
+			fmt.Println("Rollback is disabled, the completed steps are left on the clusters")
+		}
+	} else {
+		rollback(run.completed)
+	}
+
+	return errors.Join(run.errs...)
+}
+
+// stage run the cluster chains of the stage, the output of every step is grouped when more cluster run at the same time
+func (run *execution) stage(stage []step) {
+	clusterChains := chains(stage)
+
+	limit := parallel
+	if limit == 0 || limit > len(clusterChains) {
+		limit = len(clusterChains)
+	}
+	grouped := limit > 1
+
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, chain := range clusterChains {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(chain []step) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			for _, s := range chain {
+				if run.failed() {
+					return
+				}
+				run.step(s, grouped)
+			}
+		}(chain)
+	}
+	wg.Wait()
+}
+
+// step run one step, in grouped mode the progress messages of the step are collected and written together when it is finished
+func (run *execution) step(s step, grouped bool) {
+	var buffer bytes.Buffer
+	if grouped && s.kubeconfig != nil {
+		kubereflex.SetClusterOutput(s.kubeconfig, s.context, &buffer)
+		defer kubereflex.SetClusterOutput(s.kubeconfig, s.context, nil)
+	}
+
+	if jsonOutput() {
+		emit(s.event(statusStarted, 0, nil))
+	} else if grouped {
+		printLine("[%s] Start to %s %s\n", s.cluster, s.action, s.object)
+	}
+
+	start := time.Now()
+	err := s.run()
+
+	if grouped {
+		printGroup(s.cluster, buffer.String())
+	}
+
+	run.lock.Lock()
+	defer run.lock.Unlock()
+
+	if err != nil {
+		run.errs = append(run.errs, err)
+		if s.undoFailed {
+			run.completed = append(run.completed, s)
+		}
+		if jsonOutput() {
+			emit(s.event(statusFailed, time.Since(start), err))
+		} else {
+			printLine("Oops! Failed to %s %s on %s: %s\n", s.action, s.object, s.where(), err)
+		}
+		return
+	}
+
+	if jsonOutput() {
+		emit(s.event(statusSucceeded, time.Since(start), nil))
+	} else if grouped {
+		printLine("[%s] Done: %s %s\n", s.cluster, s.action, s.object)
+	}
+
+	run.completed = append(run.completed, s)
+}
+
+// failed tell a step of the plan is failed
+func (run *execution) failed() bool {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+
+	return len(run.errs) != 0
+}
+
+// printLine write a message of the plan to the message output without breaking the messages of the other clusters
+func printLine(format string, a ...interface{}) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+
+	fmt.Fprintf(messageOutput(), format, a...)
+}
+
+// printGroup write the collected progress messages of a step together, every line start with the cluster name
+func printGroup(cluster string, messages string) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(messages, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fmt.Fprintf(messageOutput(), "[%s] %s\n", cluster, line)
+	}
+}
+
+// rollback undo the completed steps in reverse order, a failed undo is reported and the rollback continues
+func rollback(completed []step) {
+	for i := len(completed) - 1; i >= 0; i-- {
+		s := completed[i]
+		if s.undo == nil {
+			continue
+		}
+
+		if !jsonOutput() {
+			fmt.Printf("Rollback %s %s on %s\n", s.action, s.object, s.where())
+		}
+
+		start := time.Now()
+		err := s.undo()
+		if jsonOutput() {
+			status := statusRolledBack
+			if err != nil {
+				status = statusRollbackFailed
+			}
+			emit(s.event(status, time.Since(start), err))
+		} else if err != nil {
+			fmt.Printf("Rollback of %s %s on %s failed, please clean it up manually: %s\n", s.action, s.object, s.where(), err)
+		}
+	}
+}
+
+// resourceNames return the objects of the custom resource file for the plan details
+func resourceNames(CRDPath string) []string {
+	names, err := kubereflex.ResourceNames(CRDPath)
+	checkErr(err)
+
+	return names
+}
+
+// meshObjects list the cluster-registry objects which are exchanged between every pair of the members
+func meshObjects(members []kubereflex.MeshMember) []string {
+	objects := []string{}
+	for i := range members {
+		for j := range members {
+			if i == j {
+				continue
+			}
+			objects = append(objects, fmt.Sprintf("Secret %s/%s and Cluster %s on %s",
+				members[i].Namespace, members[j].Name, members[j].Name, members[i].Name))
+		}
+	}
+
+	return objects
+}
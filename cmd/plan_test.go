@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder remember the order of the run and undo calls of the test steps
+type recorder struct {
+	lock  sync.Mutex
+	calls []string
+	// running is the number of the steps which run now, max is the most which run at the same time
+	running int
+	max     int
+}
+
+func (r *recorder) record(call string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.calls = append(r.calls, call)
+}
+
+func (r *recorder) index(call string) int {
+	for i, c := range r.calls {
+		if c == call {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// step return a step of the cluster which is recorded by its name, it fail with err and it can be undone when undo is true
+func (r *recorder) step(name string, cluster string, err error, undo bool) step {
+	s := step{
+		action:  "test",
+		object:  name,
+		cluster: cluster,
+		run: func() error {
+			r.lock.Lock()
+			r.running++
+			if r.running > r.max {
+				r.max = r.running
+			}
+			r.lock.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+			r.record("run " + name)
+
+			r.lock.Lock()
+			r.running--
+			r.lock.Unlock()
+
+			return err
+		},
+	}
+	if undo {
+		s.undo = func() error {
+			r.record("undo " + name)
+			return nil
+		}
+	}
+
+	return s
+}
+
+func setParallel(t *testing.T, value int) {
+	parallel = value
+	t.Cleanup(func() { parallel = 0 })
+}
+
+func TestStages(t *testing.T) {
+	r := &recorder{}
+	p := &plan{name: "Test"}
+	p.add(r.step("repositories", localMachine, nil, false))
+	p.add(r.step("a1", "a", nil, false))
+	p.add(r.step("b1", "b", nil, false))
+	p.add(r.step("a2", "a", nil, false))
+	p.add(r.step("mesh", everyCluster, nil, false))
+	p.add(r.step("a3", "a", nil, false))
+	p.add(r.step("b2", "b", nil, false))
+
+	stages := p.stages()
+	sizes := []int{}
+	for _, stage := range stages {
+		sizes = append(sizes, len(stage))
+	}
+	if fmt.Sprint(sizes) != "[1 3 1 2]" {
+		t.Fatalf("Expected stages with 1, 3, 1 and 2 steps, got %v", sizes)
+	}
+
+	chain := []string{}
+	for _, s := range chains(stages[1])[0] {
+		chain = append(chain, s.object)
+	}
+	if strings.Join(chain, ",") != "a1,a2" {
+		t.Errorf("Expected the steps of cluster a in plan order, got %v", chain)
+	}
+
+	setParallel(t, 0)
+	err := p.perform()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.calls) != 7 {
+		t.Fatalf("Expected every step to run, got %v", r.calls)
+	}
+	if r.calls[0] != "run repositories" {
+		t.Errorf("Expected the local machine step first, got %v", r.calls)
+	}
+	mesh := r.index("run mesh")
+	for _, before := range []string{"run a1", "run a2", "run b1"} {
+		if r.index(before) > mesh {
+			t.Errorf("Expected %s before the every cluster step, got %v", before, r.calls)
+		}
+	}
+	for _, after := range []string{"run a3", "run b2"} {
+		if r.index(after) < mesh {
+			t.Errorf("Expected %s after the every cluster step, got %v", after, r.calls)
+		}
+	}
+	if r.index("run a1") > r.index("run a2") {
+		t.Errorf("Expected a1 before a2 on the same cluster, got %v", r.calls)
+	}
+}
+
+func TestParallelLimit(t *testing.T) {
+	tests := []struct {
+		parallel int
+		max      int
+	}{
+		{parallel: 0, max: 4},
+		{parallel: 1, max: 1},
+		{parallel: 2, max: 2},
+		{parallel: 10, max: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("parallel %d", test.parallel), func(t *testing.T) {
+			r := &recorder{}
+			p := &plan{name: "Test"}
+			for _, cluster := range []string{"a", "b", "c", "d"} {
+				p.add(r.step(cluster+"1", cluster, nil, false))
+				p.add(r.step(cluster+"2", cluster, nil, false))
+			}
+
+			setParallel(t, test.parallel)
+			err := p.perform()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.max != test.max {
+				t.Errorf("Expected at most %d steps at the same time, got %d", test.max, r.max)
+			}
+			if len(r.calls) != 8 {
+				t.Errorf("Expected every step to run, got %v", r.calls)
+			}
+		})
+	}
+}
+
+func TestParallelInvalid(t *testing.T) {
+	setParallel(t, -1)
+
+	p := &plan{name: "Test"}
+	err := p.perform()
+	if err == nil || !strings.Contains(err.Error(), "invalid --parallel -1") {
+		t.Errorf("Expected invalid --parallel error, got %v", err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	r := &recorder{}
+	p := &plan{name: "Test"}
+	p.add(r.step("a1", "a", nil, true))
+	p.add(r.step("a2", "a", nil, false))
+	p.add(r.step("a3", "a", nil, true))
+	p.add(r.step("a4", "a", errFailed, true))
+	p.add(r.step("a5", "a", nil, true))
+	p.add(r.step("mesh", everyCluster, nil, true))
+
+	setParallel(t, 1)
+	err := p.perform()
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Expected the error of the failed step, got %v", err)
+	}
+
+	// the failed step and the steps after it are not undone, a2 has nothing to undo
+	expected := "run a1,run a2,run a3,run a4,undo a3,undo a1"
+	if strings.Join(r.calls, ",") != expected {
+		t.Errorf("Expected %s, got %v", expected, r.calls)
+	}
+}
+
+func TestRollbackFailedStep(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	r := &recorder{}
+	p := &plan{name: "Test"}
+	p.add(r.step("a1", "a", nil, true))
+	failed := r.step("a2", "a", errFailed, true)
+	failed.undoFailed = true
+	p.add(failed)
+	p.add(r.step("a3", "a", nil, true))
+
+	setParallel(t, 1)
+	err := p.perform()
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Expected the error of the failed step, got %v", err)
+	}
+
+	// the partial change of the failed step is undone first
+	expected := "run a1,run a2,undo a2,undo a1"
+	if strings.Join(r.calls, ",") != expected {
+		t.Errorf("Expected %s, got %v", expected, r.calls)
+	}
+}
+
+func TestNoRollback(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	r := &recorder{}
+	p := &plan{name: "Test"}
+	p.add(r.step("a1", "a", nil, true))
+	p.add(r.step("b1", "b", errFailed, true))
+
+	setParallel(t, 1)
+	noRollback = true
+	t.Cleanup(func() { noRollback = false })
+
+	err := p.perform()
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Expected the error of the failed step, got %v", err)
+	}
+	if r.index("undo a1") != -1 {
+		t.Errorf("Expected no undo with --no-rollback, got %v", r.calls)
+	}
+}
+
+func TestMessageOutput(t *testing.T) {
+	t.Cleanup(func() {
+		eventOutput = "text"
+		output = "table"
+	})
+
+	tests := []struct {
+		eventOutput string
+		output      string
+		stderr      bool
+	}{
+		{eventOutput: "text", output: "table"},
+		{eventOutput: "json", output: "table", stderr: true},
+		{eventOutput: "text", output: "json", stderr: true},
+		{eventOutput: "text", output: "yaml", stderr: true},
+	}
+
+	for _, test := range tests {
+		eventOutput = test.eventOutput
+		output = test.output
+
+		if (messageOutput() == os.Stderr) != test.stderr {
+			t.Errorf("Expected the messages on the standard error %v with %s events and %s status output", test.stderr, test.eventOutput, test.output)
+		}
+	}
+}
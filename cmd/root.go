@@ -20,17 +20,32 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/arpad-csepi/KLI/kubereflex"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
 var cfgFile string
 
+// nonInteractive disable the prompts, the missing contexts are reported as error
+var nonInteractive bool
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "KLI",
 	Short: "This is a CLI program for kubereflex library",
-	Long: "This CLI helps you automatize some kubernetes tasks with kubereflex library.",
+	Long:  "This CLI helps you automatize some kubernetes tasks with kubereflex library.",
+	// the flags which are not given on the command line are set from the KLI_* environment variables and the config file
+	PersistentPreRun: func(command *cobra.Command, _ []string) {
+		_, err := bindConfig(command)
+		checkErr(err)
+
+		// a prompt hang or fail without terminal, e.g. in CI, so the missing contexts are reported instead
+		if nonInteractive || !term.IsTerminal(int(os.Stdin.Fd())) {
+			kubereflex.SetPrompter(kubereflex.NonInteractivePrompter{})
+		}
+	},
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
@@ -41,7 +56,7 @@ var rootCmd = &cobra.Command{
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 }
 
@@ -53,6 +68,7 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.KLI.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&nonInteractive, "non-interactive", false, "Never prompt, fail with the available contexts when a context is not given (enabled when the standard input is not a terminal)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -70,15 +86,17 @@ func initConfig() {
 		cobra.CheckErr(err)
 
 		// Search config in home directory with name ".KLI" (without extension).
+		// The config file is optional, the keys are the flag names, e.g. timeout: 120 or install.verify: true
 		viper.AddConfigPath(home)
 		viper.SetConfigType("yaml")
 		viper.SetConfigName(".KLI")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
-
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
+	// If a config file is found, read it in. The environment variables are read by bindConfig, they have precedence over the config file
+	err := viper.ReadInConfig()
+	if err == nil {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	} else if cfgFile != "" {
+		checkErr(fmt.Errorf("config file %s: %w", cfgFile, err))
 	}
 }
@@ -0,0 +1,138 @@
+/*
+Copyright © 2022 Árpád Csepi csepi.arpad@outlook.com
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/arpad-csepi/KLI/kubereflex"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/yaml"
+)
+
+type clusterStatus struct {
+	Name string `json:"name"`
+	kubereflex.ClusterStatus
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the istio-operator and cluster-registry-controller installation",
+	Long:  "Status command is report the helm releases, workload readiness, istio control planes and cluster-registry peers of every cluster",
+	Run: func(_ *cobra.Command, _ []string) {
+		if output != "table" && output != "json" && output != "yaml" {
+			checkErr(fmt.Errorf("unknown output format %q, use table, json or yaml", output))
+		}
+
+		// the prompt and progress messages would break the JSON or YAML report, so they go to the standard error
+		if documentOutput() {
+			kubereflex.SetOutput(os.Stderr)
+		}
+
+		clusterTopology := getTopology()
+
+		releases := []kubereflex.ReleaseRef{}
+		for _, chart := range clusterTopology.Charts {
+			releases = append(releases, kubereflex.ReleaseRef{Name: chart.Release, Namespace: chart.Namespace})
+		}
+
+		statuses := []clusterStatus{}
+		for i := range clusterTopology.Clusters {
+			cluster := &clusterTopology.Clusters[i]
+
+			status, err := kubereflex.Status(releases, clusterTopology.RegistryNamespace, &cluster.Kubeconfig, cluster.Context)
+			checkErr(err)
+
+			statuses = append(statuses, clusterStatus{
+				Name:          cluster.Name,
+				ClusterStatus: status,
+			})
+		}
+
+		switch output {
+		case "json":
+			data, err := json.MarshalIndent(statuses, "", "  ")
+			checkErr(err)
+			fmt.Println(string(data))
+		case "yaml":
+			data, err := yaml.Marshal(statuses)
+			checkErr(err)
+			fmt.Print(string(data))
+		default:
+			printStatusTable(statuses)
+		}
+	},
+}
+
+var output string
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVarP(&output, "output", "o", "table", "Output format: table, json or yaml")
+
+	statusCmd.Flags().StringVarP(&mainClusterConfigPath, "main-cluster", "c", "", "Main cluster kubeconfig file location")
+	statusCmd.Flags().StringVarP(&secondaryClusterConfigPath, "secondary-cluster", "C", "", "Secondary cluster kubeconfig file location")
+
+	statusCmd.Flags().StringVarP(&mainContext, "main-context", "k", "", "Main cluster context name")
+	statusCmd.Flags().StringVarP(&secondaryContext, "secondary-context", "K", "", "Secondary cluster context name")
+
+	addClusterNameFlags(statusCmd)
+	addTopologyFlag(statusCmd)
+	addSingleClusterFlag(statusCmd)
+}
+
+// printStatusTable print the cluster statuses as human readable tables
+func printStatusTable(statuses []clusterStatus) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+
+	for _, status := range statuses {
+		fmt.Fprintf(writer, "Cluster %s (context %s)\n\n", status.Name, status.Context)
+
+		fmt.Fprintln(writer, "RELEASE\tNAMESPACE\tCHART\tVERSION\tSTATUS\tREVISION\tWORKLOADS\tREADY")
+		notReady := []kubereflex.WorkloadStatus{}
+		for _, release := range status.Releases {
+			ready := 0
+			for _, workload := range release.Workloads {
+				if workload.Ready {
+					ready++
+				} else {
+					notReady = append(notReady, workload)
+				}
+			}
+
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\t%d/%d\t%t\n",
+				release.Name, release.Namespace, release.Chart, release.Version, release.Status, release.Revision, ready, len(release.Workloads), release.Ready)
+		}
+		fmt.Fprintln(writer)
+
+		if len(notReady) > 0 {
+			fmt.Fprintln(writer, "NOT READY WORKLOAD\tREASON")
+			for _, workload := range notReady {
+				fmt.Fprintf(writer, "%s\t%s\n", workload.Workload, workload.Reason)
+			}
+			fmt.Fprintln(writer)
+		}
+
+		fmt.Fprintln(writer, "CONTROL PLANE\tNAMESPACE\tVERSION\tMODE\tSTATUS")
+		for _, controlPlane := range status.ControlPlanes {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n",
+				controlPlane.Name, controlPlane.Namespace, controlPlane.Version, controlPlane.Mode, controlPlane.Status)
+		}
+		fmt.Fprintln(writer)
+
+		fmt.Fprintln(writer, "PEER\tTYPE\tSTATE\tSECRET")
+		for _, peer := range status.Peers {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%t\n", peer.Name, peer.Type, peer.State, peer.Secret)
+		}
+		fmt.Fprintln(writer)
+	}
+
+	writer.Flush()
+}
@@ -0,0 +1,165 @@
+/*
+Copyright © 2022 Árpád Csepi csepi.arpad@outlook.com
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/arpad-csepi/KLI/kubereflex"
+	"github.com/arpad-csepi/KLI/kubereflex/topology"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultCharts are the charts which are installed when no topology file is given
+var defaultCharts = []topology.Chart{
+	{
+		URL:        "https://cisco-open.github.io/cluster-registry-controller",
+		Repository: "cluster-registry",
+		Name:       "cluster-registry",
+		Release:    "cluster-registry",
+		Namespace:  "cluster-registry",
+		ChartValues: topology.ChartValues{
+			Set: "localCluster.name={{ .Name }},network.name={{ .Network }},controller.apiServerEndpointAddress={{ .APIServerEndpoint }}",
+		},
+	},
+	{
+		URL:        "https://kubernetes-charts.banzaicloud.com",
+		Repository: "banzaicloud-stable",
+		Name:       "istio-operator",
+		Release:    "banzaicloud-stable",
+		Namespace:  "istio-system",
+		ChartValues: topology.ChartValues{
+			Set: "clusterRegistry.clusterAPI.enabled=true,clusterRegistry.resourceSyncRules.enabled=true",
+		},
+	},
+}
+
+var topologyPath string
+
+// the cluster-registry names of the clusters when no topology file is given, they have to be unique across the meshes which share a cluster
+var mainClusterName string
+var secondaryClusterName string
+var mainNetwork string
+var secondaryNetwork string
+
+// addClusterNameFlags register the cluster and network name flags of the main and the secondary cluster
+func addClusterNameFlags(command *cobra.Command) {
+	command.Flags().StringVar(&mainClusterName, "main-cluster-name", "demo-active", "Cluster-registry name of the main cluster")
+	command.Flags().StringVar(&secondaryClusterName, "secondary-cluster-name", "demo-passive", "Cluster-registry name of the secondary cluster")
+	command.Flags().StringVar(&mainNetwork, "main-network", "network1", "Network name of the main cluster")
+	command.Flags().StringVar(&secondaryNetwork, "secondary-network", "network2", "Network name of the secondary cluster")
+}
+
+// getTopology load the topology file if it is given, otherwise build the topology from the cluster flags, only with the main cluster in single-cluster mode
+func getTopology() *topology.Topology {
+	var clusterTopology *topology.Topology
+
+	if topologyPath != "" {
+		var err error
+		clusterTopology, err = topology.Load(topologyPath)
+		checkErr(err)
+	} else {
+		clusters := []topology.Cluster{
+			{
+				Name:           mainClusterName,
+				Kubeconfig:     mainClusterConfigPath,
+				Context:        mainContext,
+				Network:        mainNetwork,
+				CustomResource: activeCRDPath,
+			},
+		}
+
+		// in single-cluster mode the charts are installed only once and there is no cluster to attach
+		if !singleCluster {
+			if secondaryClusterConfigPath == "" {
+				secondaryClusterConfigPath = mainClusterConfigPath
+			}
+
+			clusters = append(clusters, topology.Cluster{
+				Name:           secondaryClusterName,
+				Kubeconfig:     secondaryClusterConfigPath,
+				Context:        secondaryContext,
+				Network:        secondaryNetwork,
+				CustomResource: passiveCRDPath,
+			})
+		}
+
+		clusterTopology = &topology.Topology{
+			Clusters:          clusters,
+			Charts:            defaultCharts,
+			RegistryNamespace: topology.DefaultRegistryNamespace,
+		}
+		checkErr(clusterTopology.Validate())
+	}
+
+	var defaultKubeconfig *string
+	for i := range clusterTopology.Clusters {
+		cluster := &clusterTopology.Clusters[i]
+
+		if cluster.Kubeconfig == "" {
+			if defaultKubeconfig == nil {
+				defaultKubeconfig = getKubeConfig()
+			}
+			cluster.Kubeconfig = *defaultKubeconfig
+		}
+
+		if cluster.Context == "" {
+			fmt.Fprintf(messageOutput(), "%s cluster context switcher:\n", cluster.Name)
+			context, err := kubereflex.ChooseContextFromConfig(&cluster.Kubeconfig)
+			checkErr(err)
+			cluster.Context = context
+		}
+	}
+
+	return clusterTopology
+}
+
+// meshMembers return every cluster of the topology as attach and detach member
+func meshMembers(clusterTopology *topology.Topology) []kubereflex.MeshMember {
+	members := []kubereflex.MeshMember{}
+	for i := range clusterTopology.Clusters {
+		cluster := &clusterTopology.Clusters[i]
+		members = append(members, kubereflex.MeshMember{
+			Kubeconfig: &cluster.Kubeconfig,
+			Context:    cluster.Context,
+			Name:       cluster.Name,
+			Namespace:  clusterTopology.RegistryNamespace,
+		})
+	}
+
+	return members
+}
+
+// clusterFlags describe the clusters without topology file, they cannot be used together with the --topology flag
+var clusterFlags = map[string]bool{
+	"main-cluster": true, "secondary-cluster": true, "main-context": true, "secondary-context": true, "active-custom-resource": true, "passive-custom-resource": true,
+	"main-cluster-name": true, "secondary-cluster-name": true, "main-network": true, "secondary-network": true,
+}
+
+// addTopologyFlag register the --topology flag which cannot be used together with the cluster flags
+func addTopologyFlag(command *cobra.Command) {
+	command.Flags().StringVarP(&topologyPath, "topology", "T", "", "Topology file (YAML or JSON) which describes the clusters, charts and custom resources")
+
+	for flagName := range clusterFlags {
+		if command.Flags().Lookup(flagName) != nil {
+			command.MarkFlagsMutuallyExclusive("topology", flagName)
+		}
+	}
+}
+
+// singleCluster use only the main cluster, the secondary cluster flags are ignored and nothing is attached or detached
+var singleCluster bool
+
+// addSingleClusterFlag register the --single-cluster flag which cannot be used together with the secondary cluster, attach, detach and topology flags
+func addSingleClusterFlag(command *cobra.Command) {
+	command.Flags().BoolVar(&singleCluster, "single-cluster", false, "Use only the main cluster: the charts are installed once, the active custom resource is applied and there is no attach")
+
+	exclusiveFlags := []string{"topology", "secondary-cluster", "secondary-context", "passive-custom-resource", "secondary-cluster-name", "secondary-network", "attach", "detach"}
+	for _, flagName := range exclusiveFlags {
+		if command.Flags().Lookup(flagName) != nil {
+			command.MarkFlagsMutuallyExclusive("single-cluster", flagName)
+		}
+	}
+}
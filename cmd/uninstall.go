@@ -5,57 +5,126 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/arpad-csepi/KLI/kubereflex"
+	"github.com/arpad-csepi/KLI/kubereflex/kubectl"
+	"github.com/arpad-csepi/KLI/kubereflex/topology"
 	"github.com/spf13/cobra"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // uninstallCmd represents the uninstall command
 var uninstallCmd = &cobra.Command{
 	Use:   "uninstall",
 	Short: "Uninstall istio-operator and cluster-registry-controller",
-	Long: "Uninstall command is uninstall charts deployment with helm package manager and clean-up depends on other parameters",
+	Long:  "Uninstall command is uninstall charts deployment with helm package manager and clean-up depends on other parameters",
 	Run: func(_ *cobra.Command, _ []string) {
-		if mainClusterConfigPath == "" {
-			mainClusterConfigPath = *getKubeConfig()
-		}
+		uninstallPlan := buildUninstallPlan(getTopology())
 
-		if mainContext == "" {
-			fmt.Println("Main cluster context switcher:")
-			mainContext = kubereflex.ChooseContextFromConfig(&mainClusterConfigPath)
+		if dryRun {
+			uninstallPlan.print()
+			return
 		}
 
-		if activeCRDPath != "" {
-			kubereflex.Remove(activeCRDPath, &mainClusterConfigPath, mainContext)
-		}
+		uninstallPlan.execute()
+	},
+}
 
-		kubereflex.UninstallHelmChart("cluster-registry", "cluster-registry", &mainClusterConfigPath, mainContext)
-		kubereflex.UninstallHelmChart("banzaicloud-stable", "istio-system", &mainClusterConfigPath, mainContext)
+// buildUninstallPlan collect the uninstall steps in order, in dry run mode the installed chart versions are resolved
+func buildUninstallPlan(clusterTopology *topology.Topology) *plan {
+	uninstallPlan := &plan{name: "Uninstall"}
+	options := removeOptions()
 
-		if secondaryClusterConfigPath == "" {
-			secondaryClusterConfigPath = mainClusterConfigPath
+	if (detach || clusterTopology.Attach) && len(clusterTopology.Clusters) > 1 {
+		members := meshMembers(clusterTopology)
+
+		uninstallPlan.add(step{
+			action:  "detach",
+			object:  "cluster-registry peers",
+			cluster: everyCluster,
+			details: meshObjects(members),
+			run: func() error {
+				_, err := kubereflex.Detach(members...)
+				return err
+			},
+		})
+	}
+
+	for i := range clusterTopology.Clusters {
+		cluster := &clusterTopology.Clusters[i]
+
+		if cluster.CustomResource != "" {
+			uninstallPlan.add(step{
+				action:     "remove",
+				object:     "custom resource " + cluster.CustomResource,
+				cluster:    cluster.Name,
+				kubeconfig: &cluster.Kubeconfig,
+				context:    cluster.Context,
+				details:    resourceNames(cluster.CustomResource),
+				run: func() error {
+					return kubereflex.Remove(cluster.CustomResource, options, &cluster.Kubeconfig, cluster.Context)
+				},
+			})
 		}
-		if secondaryClusterConfigPath != "" {
-			if secondaryContext == "" {
-				fmt.Println("Main cluster context switcher:")
-				secondaryContext = kubereflex.ChooseContextFromConfig(&secondaryClusterConfigPath)
-			}
-			
-			if passiveCRDPath != "" {
-				kubereflex.Remove(passiveCRDPath, &secondaryClusterConfigPath, secondaryContext)
-			}
 
-			kubereflex.UninstallHelmChart("cluster-registry", "cluster-registry", &secondaryClusterConfigPath, secondaryContext)
-			kubereflex.UninstallHelmChart("banzaicloud-stable", "istio-system", &secondaryClusterConfigPath, secondaryContext)
+		for _, chart := range clusterTopology.Charts {
+			release := chart.Release
+			namespace := chart.Namespace
 
-			if detach {
-				kubereflex.Detach(&mainClusterConfigPath, mainContext, &secondaryClusterConfigPath, secondaryContext, "cluster-registry", "cluster-registry")
+			uninstallStep := step{
+				action:     "uninstall",
+				object:     fmt.Sprintf("helm release %s/%s", namespace, release),
+				cluster:    cluster.Name,
+				kubeconfig: &cluster.Kubeconfig,
+				context:    cluster.Context,
+				run: func() error {
+					return kubereflex.UninstallHelmChart(release, namespace, &cluster.Kubeconfig, cluster.Context)
+				},
 			}
+
+			if dryRun {
+				helmRelease, err := kubereflex.GetHelmRelease(release, namespace, &cluster.Kubeconfig, cluster.Context)
+				checkErr(err)
+				if helmRelease == nil {
+					uninstallStep.details = []string{"not installed, nothing to do"}
+				} else {
+					uninstallStep.details = []string{fmt.Sprintf("chart %s %s, revision %d, %s",
+						helmRelease.Chart.Metadata.Name, helmRelease.Chart.Metadata.Version, helmRelease.Version, helmRelease.Info.Status)}
+				}
+			}
+
+			uninstallPlan.add(uninstallStep)
 		}
-	},
+	}
+
+	return uninstallPlan
+}
+
+// removeOptions return how the objects of the custom resource files are deleted by the --cascade, --wait and --timeout flags
+func removeOptions() kubectl.RemoveOptions {
+	policies := map[string]metav1.DeletionPropagation{
+		"background": metav1.DeletePropagationBackground,
+		"foreground": metav1.DeletePropagationForeground,
+		"orphan":     metav1.DeletePropagationOrphan,
+	}
+
+	policy, ok := policies[cascade]
+	if !ok {
+		checkErr(fmt.Errorf("invalid --cascade %s, it must be background, foreground or orphan", cascade))
+	}
+
+	return kubectl.RemoveOptions{
+		PropagationPolicy: policy,
+		Wait:              wait,
+		Timeout:           time.Duration(timeout) * time.Second,
+	}
 }
 
 var detach bool
+var cascade string
+var wait bool
 
 func init() {
 	rootCmd.AddCommand(uninstallCmd)
@@ -70,4 +139,13 @@ func init() {
 	uninstallCmd.Flags().StringVarP(&secondaryContext, "secondary-context", "K", "", "Secondary cluster context name")
 
 	uninstallCmd.Flags().BoolVarP(&detach, "detach", "d", false, "Remove cluster connections")
+	uninstallCmd.Flags().StringVar(&cascade, "cascade", "background", "Deletion propagation of the custom resources: background, foreground or orphan")
+	uninstallCmd.Flags().BoolVar(&wait, "wait", false, "Wait until the removed custom resources are gone from the cluster (their finalizers are processed)")
+	uninstallCmd.Flags().IntVarP(&timeout, "timeout", "t", 60, "Set the wait timeout of the removed custom resources in seconds")
+	addParallelFlag(uninstallCmd)
+	uninstallCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the uninstall plan without changing the clusters")
+	addOutputFlag(uninstallCmd)
+	addClusterNameFlags(uninstallCmd)
+	addTopologyFlag(uninstallCmd)
+	addSingleClusterFlag(uninstallCmd)
 }
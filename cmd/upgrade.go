@@ -0,0 +1,94 @@
+/*
+Copyright © 2022 Árpád Csepi csepi.arpad@outlook.com
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/arpad-csepi/KLI/kubereflex"
+	"github.com/arpad-csepi/KLI/kubereflex/topology"
+
+	"github.com/spf13/cobra"
+)
+
+// upgradeCmd represents the upgrade command
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade istio-operator and cluster-registry-controller",
+	Long:  "Upgrade command is upgrade the existing chart releases on every cluster to the target chart version with new values and verify the deployments afterwards",
+	Run: func(_ *cobra.Command, _ []string) {
+		upgradePlan := buildUpgradePlan(getTopology())
+
+		upgradePlan.execute()
+	},
+}
+
+// buildUpgradePlan collect the upgrade and verify steps of every chart release on every cluster
+func buildUpgradePlan(clusterTopology *topology.Topology) *plan {
+	upgradePlan := &plan{name: "Upgrade"}
+
+	checkReleaseFlags(clusterTopology)
+
+	apiServerEndpoints := getAPIServerEndpoints(clusterTopology)
+
+	upgradePlan.add(repositoriesStep(clusterTopology))
+
+	for _, chart := range clusterTopology.Charts {
+		for i := range clusterTopology.Clusters {
+			cluster := &clusterTopology.Clusters[i]
+
+			upgradeChart := newChartData(chart, cluster, apiServerEndpoints[cluster.Name])
+			upgradeChart.arguments["reuse-values"] = fmt.Sprint(reuseValues)
+			upgradeChart.arguments["reset-values"] = fmt.Sprint(resetValues)
+
+			upgradePlan.add(step{
+				action:     "upgrade",
+				object:     fmt.Sprintf("helm release %s/%s (chart %s)", upgradeChart.namespace, upgradeChart.releaseName, upgradeChart.chartLabel()),
+				cluster:    cluster.Name,
+				kubeconfig: &cluster.Kubeconfig,
+				context:    cluster.Context,
+				run: func() error {
+					_, err := kubereflex.UpgradeHelmChart(upgradeChart.chartUrl,
+						upgradeChart.repositoryName,
+						upgradeChart.chartName,
+						upgradeChart.releaseName,
+						upgradeChart.namespace,
+						upgradeChart.arguments,
+						upgradeChart.valueLevels,
+						&cluster.Kubeconfig,
+						cluster.Context)
+					return err
+				},
+			})
+
+			upgradePlan.add(verifyStep(upgradeChart, cluster))
+		}
+	}
+
+	return upgradePlan
+}
+
+var reuseValues bool
+var resetValues bool
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+
+	addVersionFlags(upgradeCmd)
+	upgradeCmd.Flags().BoolVar(&reuseValues, "reuse-values", false, "Reuse the values of the last release and merge the new values into them")
+	upgradeCmd.Flags().BoolVar(&resetValues, "reset-values", false, "Reset the values to the chart defaults before the new values are applied")
+	upgradeCmd.MarkFlagsMutuallyExclusive("reuse-values", "reset-values")
+	addValueFlags(upgradeCmd)
+	upgradeCmd.Flags().IntVarP(&timeout, "timeout", "t", 60, "Set verify timeout in seconds")
+
+	upgradeCmd.Flags().StringVarP(&mainClusterConfigPath, "main-cluster", "c", "", "Main cluster kubeconfig file location")
+	upgradeCmd.Flags().StringVarP(&secondaryClusterConfigPath, "secondary-cluster", "C", "", "Secondary cluster kubeconfig file location")
+	upgradeCmd.Flags().StringVarP(&mainContext, "main-context", "k", "", "Main cluster context name")
+	upgradeCmd.Flags().StringVarP(&secondaryContext, "secondary-context", "K", "", "Secondary cluster context name")
+	addParallelFlag(upgradeCmd)
+	addOutputFlag(upgradeCmd)
+	addClusterNameFlags(upgradeCmd)
+	addTopologyFlag(upgradeCmd)
+	addSingleClusterFlag(upgradeCmd)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright © 2022 Árpád Csepi csepi.arpad@outlook.com
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/arpad-csepi/KLI/kubereflex/topology"
+
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/cli/values"
+)
+
+var chartVersions map[string]string
+var devel bool
+
+var valuesFiles []string
+var setValues []string
+var setStringValues []string
+var setFileValues []string
+var setJSONValues []string
+
+// addVersionFlags register the chart version flags, the --version values override the version of the topology charts
+func addVersionFlags(command *cobra.Command) {
+	command.Flags().StringToStringVar(&chartVersions, "version", map[string]string{}, "Chart version or semver constraint per release, e.g. cluster-registry=0.2.11,banzaicloud-stable=~2.17.0 (default is the topology version or the latest)")
+	command.Flags().BoolVar(&devel, "devel", false, "Use development chart versions too, equivalent to version '>0.0.0-0' when no version is set")
+}
+
+// applyVersionFlags set the chart version and devel helm arguments of the release from the flags
+func applyVersionFlags(release string, args map[string]string) {
+	if version, exists := chartVersions[release]; exists {
+		args["version"] = version
+	}
+	if devel {
+		args["devel"] = "true"
+	}
+}
+
+// addValueFlags register the helm value flags, every flag value has release=value form and can be repeated
+func addValueFlags(command *cobra.Command) {
+	command.Flags().StringArrayVarP(&valuesFiles, "values", "f", []string{}, "Values file of a release in release=path form, e.g. cluster-registry=registry-values.yaml")
+	command.Flags().StringArrayVar(&setValues, "set", []string{}, "Set values of a release in release=key=value,... form")
+	command.Flags().StringArrayVar(&setStringValues, "set-string", []string{}, "Set string values of a release in release=key=value,... form")
+	command.Flags().StringArrayVar(&setFileValues, "set-file", []string{}, "Set values of a release from files in release=key=path,... form")
+	command.Flags().StringArrayVar(&setJSONValues, "set-json", []string{}, "Set JSON values of a release in release=key=json,... form")
+}
+
+// valueFlag is a helm value flag with the values which are given on the command line
+type valueFlag struct {
+	argument string
+	values   []string
+}
+
+// valueFlags return the value flags in helm precedence order
+func valueFlags() []valueFlag {
+	return []valueFlag{
+		{argument: "values", values: valuesFiles},
+		{argument: "set-json", values: setJSONValues},
+		{argument: "set", values: setValues},
+		{argument: "set-string", values: setStringValues},
+		{argument: "set-file", values: setFileValues},
+	}
+}
+
+// checkReleaseFlags check that the per release flags refer to a chart release of the topology
+func checkReleaseFlags(clusterTopology *topology.Topology) {
+	releases := map[string]bool{}
+	for _, chart := range clusterTopology.Charts {
+		releases[chart.Release] = true
+	}
+
+	for release, version := range chartVersions {
+		if !releases[release] {
+			checkErr(fmt.Errorf("--version %s: no chart with this release name", release))
+		}
+		if _, err := semver.NewConstraint(version); err != nil {
+			checkErr(fmt.Errorf("--version %s=%s: %w", release, version, err))
+		}
+	}
+
+	for _, flag := range valueFlags() {
+		for _, flagValue := range flag.values {
+			release, _, found := strings.Cut(flagValue, "=")
+			if !found {
+				checkErr(fmt.Errorf("--%s %s: must be in release=value form", flag.argument, flagValue))
+			}
+			if !releases[release] {
+				checkErr(fmt.Errorf("--%s %s: no chart with %s release name", flag.argument, flagValue, release))
+			}
+		}
+	}
+}
+
+// flagValues return the value flags of the release as helm value options, they are the last value level, so they take precedence over the topology values
+func flagValues(release string) values.Options {
+	return values.Options{
+		ValueFiles:   releaseFlagValues(valuesFiles, release),
+		JSONValues:   releaseFlagValues(setJSONValues, release),
+		Values:       releaseFlagValues(setValues, release),
+		StringValues: releaseFlagValues(setStringValues, release),
+		FileValues:   releaseFlagValues(setFileValues, release),
+	}
+}
+
+// releaseFlagValues return the values of the release=value flag values which belong to the release
+func releaseFlagValues(flagValues []string, release string) []string {
+	releaseValues := []string{}
+	for _, flagValue := range flagValues {
+		flagRelease, value, _ := strings.Cut(flagValue, "=")
+		if flagRelease == release {
+			releaseValues = append(releaseValues, value)
+		}
+	}
+
+	return releaseValues
+}
@@ -0,0 +1,60 @@
+package kubereflex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/arpad-csepi/KLI/kubereflex/helm"
+	"github.com/arpad-csepi/KLI/kubereflex/kubectl"
+)
+
+// ErrReleaseExists is returned by InstallHelmChart when the release is already installed
+var ErrReleaseExists = helm.ErrReleaseExists
+
+// ErrDeploymentNotReady is returned by Verify when a workload of the release is not ready until the timeout or it is failed
+var ErrDeploymentNotReady = kubectl.ErrDeploymentNotReady
+
+// ErrContextNotFound is returned when the context is not in the kubeconfig or the kubeconfig has no context
+var ErrContextNotFound = kubectl.ErrContextNotFound
+
+// ErrNonInteractive is returned by ChooseContextFromConfig when a context has to be selected but the prompt is disabled with NonInteractivePrompter
+var ErrNonInteractive = errors.New("interactive prompt is disabled")
+
+// ErrApplyConflict is returned by Apply when an other field manager own a field of the custom resource which is changed
+var ErrApplyConflict = kubectl.ErrApplyConflict
+
+// ErrStuckTerminating is returned by Remove when a removed object is still on the cluster after the timeout, e.g. its finalizers are not processed
+var ErrStuckTerminating = kubectl.ErrStuckTerminating
+
+// ErrLocalClusterNotFound is in the result of an Attach pair when the cluster-registry Cluster object of a cluster is not created until the timeout
+var ErrLocalClusterNotFound = kubectl.ErrLocalClusterNotFound
+
+// MeshError is returned by Attach and Detach when one or more cluster pair failed
+type MeshError struct {
+	Operation string
+	Results   []kubectl.PairResult
+}
+
+func (e *MeshError) Error() string {
+	failed := []string{}
+	for _, result := range e.Results {
+		if result.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s <-> %s: %s", result.Source, result.Target, result.Err))
+		}
+	}
+
+	return fmt.Sprintf("%s failed for %d of %d cluster pairs: %s", strings.ToLower(e.Operation), len(failed), len(e.Results), strings.Join(failed, "; "))
+}
+
+// Unwrap return the errors of the failed pairs, so errors.Is can find the sentinel errors in them
+func (e *MeshError) Unwrap() []error {
+	errs := []error{}
+	for _, result := range e.Results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+
+	return errs
+}
@@ -3,8 +3,10 @@ package helm
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,39 +20,145 @@ import (
 	"helm.sh/helm/v3/pkg/cli/values"
 	"helm.sh/helm/v3/pkg/downloader"
 	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
 	"helm.sh/helm/v3/pkg/repo"
-	"helm.sh/helm/v3/pkg/strvals"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/yaml"
+
+	kubeio "github.com/arpad-csepi/KLI/kubereflex/io"
 )
 
-var settings *cli.EnvSettings = cli.New()
+// repositorySettings is the helm environment of the repository operations, the cluster operations use their own settings from newSettings
+var repositorySettings *cli.EnvSettings = cli.New()
+
+// repositoryLock serialize the changes of the repository file and the index cache, installs on several clusters share them
+// The changes take the write lock, the chart lookups take the read lock, so an index is never read while it is written
+var repositoryLock sync.RWMutex
+
+// ErrReleaseNotFound is returned by Status and Get when the release is not installed
+var ErrReleaseNotFound = driver.ErrReleaseNotFound
+
+// ErrReleaseExists is returned by Install when a release with the same name is already installed, a failed or uninstalled release is replaced instead
+var ErrReleaseExists = errors.New("release already exists")
+
+// newSettings return the helm settings of one call, every call has its own settings so calls on different clusters can run at the same time
+func newSettings(namespace string, kubeconfig *string, context string) *cli.EnvSettings {
+	clusterSettings := cli.New()
+	clusterSettings.SetNamespace(namespace)
+	clusterSettings.KubeConfig = *kubeconfig
+	clusterSettings.KubeContext = context
+
+	return clusterSettings
+}
+
+// kubeconfigGetter is the RESTClientGetter of the helm actions, it load the kubeconfig with the kubereflex loading rules, so a kubeconfig path list is merged like KUBECONFIG
+type kubeconfigGetter struct {
+	loader clientcmd.ClientConfig
+}
+
+// restClientGetter return the RESTClientGetter of the kubeconfig, context and namespace of the settings
+func restClientGetter(settings *cli.EnvSettings) *kubeconfigGetter {
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: settings.KubeContext}
+	overrides.Context.Namespace = settings.Namespace()
+
+	return &kubeconfigGetter{
+		loader: clientcmd.NewNonInteractiveDeferredLoadingClientConfig(kubeio.LoadingRules(settings.KubeConfig), overrides),
+	}
+}
+
+func (g *kubeconfigGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return g.loader
+}
 
-func setSettings(namespace string, kubeconfig *string, context string) {
-	os.Setenv("HELM_NAMESPACE", namespace)
-	settings.SetNamespace(namespace)
-	settings.KubeConfig = *kubeconfig
-	settings.KubeContext = context
+func (g *kubeconfigGetter) ToRESTConfig() (*rest.Config, error) {
+	return g.loader.ClientConfig()
 }
 
-// Install set helm settings up, perform repository updates and install the chart which is specified
-func Install(repositoryName string, chartName string, releaseName string, namespace string, args map[string]string, kubeconfig *string, context string) error {
-	setSettings(namespace, kubeconfig, context)
-	err := RepositoryUpdate()
+func (g *kubeconfigGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	config, err := g.ToRESTConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = installChart(releaseName, repositoryName, chartName, args)
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return memory.NewMemCacheClient(discoveryClient), nil
 }
 
-// Uninstall set helm settings up and uninstall the chart which is specified
-func Uninstall(releaseName string, namespace string, kubeconfig *string, context string) error {
-	setSettings(namespace, kubeconfig, context)
-	err := uninstallChart(releaseName)
+func (g *kubeconfigGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	discoveryClient, err := g.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient)
+	return restmapper.NewShortcutExpander(mapper, discoveryClient), nil
+}
+
+// Install set helm settings up, perform repository updates and install the chart which is specified, the progress messages are written to out
+// args["version"] is an exact chart version or semver constraint, args["devel"] = "true" allow development versions too
+// With args["dry-run"] = "true" the chart is only rendered and the release is not installed
+// args["skip-update"] = "true" skip the repository update, e.g. when the repositories are already updated before the installs
+// The values of the valueLevels are merged in order, so a later level override the same values of an earlier level
+func Install(repositoryName string, chartName string, releaseName string, namespace string, args map[string]string, valueLevels []values.Options, kubeconfig *string, context string, out io.Writer) (*release.Release, error) {
+	if args["skip-update"] != "true" {
+		err := RepositoryUpdate(out)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return installChart(newSettings(namespace, kubeconfig, context), releaseName, repositoryName, "", chartName, args, valueLevels, out)
+}
+
+// Render find the chart in the repository of the chartUrl and render the release like a dry run install, nothing is installed
+// The repository is not added to the helm repositories and the chart is downloaded to a temporary directory, so the helm repository config and cache are not changed
+func Render(chartUrl string, repositoryName string, chartName string, releaseName string, namespace string, args map[string]string, valueLevels []values.Options, kubeconfig *string, context string, out io.Writer) (*release.Release, error) {
+	cacheDir, err := os.MkdirTemp("", "kli-render-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(cacheDir)
+
+	settings := newSettings(namespace, kubeconfig, context)
+	settings.RepositoryCache = cacheDir
+
+	dryRunArgs := map[string]string{"dry-run": "true"}
+	for key, value := range args {
+		dryRunArgs[key] = value
+	}
+
+	return installChart(settings, releaseName, repositoryName, chartUrl, chartName, dryRunArgs, valueLevels, out)
+}
+
+// Upgrade set helm settings up, perform repository updates and upgrade the release to the chart which is specified
+// The target chart version or constraint is args["version"], args["devel"] = "true" allow development versions
+// args["reuse-values"] or args["reset-values"] = "true" control the previous values, the new values are the merged valueLevels like at Install
+// args["skip-update"] = "true" skip the repository update like at Install
+func Upgrade(repositoryName string, chartName string, releaseName string, namespace string, args map[string]string, valueLevels []values.Options, kubeconfig *string, context string, out io.Writer) (*release.Release, error) {
+	if args["skip-update"] != "true" {
+		err := RepositoryUpdate(out)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return upgradeChart(newSettings(namespace, kubeconfig, context), releaseName, repositoryName, chartName, args, valueLevels, out)
+}
+
+// Uninstall set helm settings up and uninstall the chart which is specified, nil is returned when the release is not installed
+func Uninstall(releaseName string, namespace string, kubeconfig *string, context string, out io.Writer) error {
+	err := uninstallChart(newSettings(namespace, kubeconfig, context), releaseName, out)
 	if err != nil {
 		return err
 	}
@@ -58,14 +166,42 @@ func Uninstall(releaseName string, namespace string, kubeconfig *string, context
 	return nil
 }
 
+// Status set helm settings up and return with the release which is specified
+func Status(releaseName string, namespace string, kubeconfig *string, context string) (*release.Release, error) {
+	clusterSettings := newSettings(namespace, kubeconfig, context)
+	actionConfig := new(action.Configuration)
+	err := actionConfig.Init(restClientGetter(clusterSettings), clusterSettings.Namespace(), os.Getenv("HELM_DRIVER"), debug)
+	if err != nil {
+		return nil, err
+	}
+
+	client := action.NewStatus(actionConfig)
+
+	return client.Run(releaseName)
+}
+
+// Get set helm settings up and return the last revision of the release with its manifest, the objects of the release are not read from the cluster
+func Get(releaseName string, namespace string, kubeconfig *string, context string) (*release.Release, error) {
+	clusterSettings := newSettings(namespace, kubeconfig, context)
+	actionConfig := new(action.Configuration)
+	err := actionConfig.Init(restClientGetter(clusterSettings), clusterSettings.Namespace(), os.Getenv("HELM_DRIVER"), debug)
+	if err != nil {
+		return nil, err
+	}
+
+	client := action.NewGet(actionConfig)
+
+	return client.Run(releaseName)
+}
+
 // IsRepositoryExists check if given repositoryName already exists in repo.File
-func IsRepositoryExists(repositoryName string) (bool, error) {
-	repoFile, err := readRepositoryFile(settings.RepositoryConfig)
+func IsRepositoryExists(repositoryName string, out io.Writer) (bool, error) {
+	repoFile, err := readRepositoryFile(repositorySettings.RepositoryConfig)
 	if err != nil {
 		return false, err
 	}
 	if repoFile.Has(repositoryName) {
-		fmt.Printf("Nice! %s already in the repos!\n", repositoryName)
+		fmt.Fprintf(out, "Nice! %s already in the repos!\n", repositoryName)
 		return true, nil
 	}
 
@@ -73,8 +209,11 @@ func IsRepositoryExists(repositoryName string) (bool, error) {
 }
 
 // RepositoryAdd adds helm repository to current helm instance
-func RepositoryAdd(repositoryName, chartUrl string) error {
-	repoFile, err := readRepositoryFile(settings.RepositoryConfig)
+func RepositoryAdd(repositoryName, chartUrl string, out io.Writer) error {
+	repositoryLock.Lock()
+	defer repositoryLock.Unlock()
+
+	repoFile, err := readRepositoryFile(repositorySettings.RepositoryConfig)
 	if err != nil {
 		return err
 	}
@@ -83,7 +222,7 @@ func RepositoryAdd(repositoryName, chartUrl string) error {
 		URL:  chartUrl,
 	}
 
-	repository, err := repo.NewChartRepository(&newChart, getter.All(settings))
+	repository, err := repo.NewChartRepository(&newChart, getter.All(repositorySettings))
 	if err != nil {
 		return err
 	}
@@ -95,144 +234,390 @@ func RepositoryAdd(repositoryName, chartUrl string) error {
 
 	repoFile.Update(&newChart)
 
-	if err := repoFile.WriteFile(settings.RepositoryConfig, 0644); err != nil {
+	if err := repoFile.WriteFile(repositorySettings.RepositoryConfig, 0644); err != nil {
 		return err
 	}
 
-	fmt.Printf("Great! %q has been added to your repositories\n", settings.RepositoryConfig)
+	fmt.Fprintf(out, "Great! %q has been added to your repositories\n", repositorySettings.RepositoryConfig)
 	return nil
 }
 
 // RepositoryUpdate updates charts for all helm repos
-func RepositoryUpdate() error {
-	repoFile, err := readRepositoryFile(settings.RepositoryConfig)
+func RepositoryUpdate(out io.Writer) error {
+	repositoryLock.Lock()
+	defer repositoryLock.Unlock()
+
+	repoFile, err := readRepositoryFile(repositorySettings.RepositoryConfig)
 	if err != nil {
 		return err
 	}
 
 	var repos []*repo.ChartRepository
 	for _, cfg := range repoFile.Repositories {
-		repository, err := repo.NewChartRepository(cfg, getter.All(settings))
+		repository, err := repo.NewChartRepository(cfg, getter.All(repositorySettings))
 		if err != nil {
 			return err
 		}
 		repos = append(repos, repository)
 	}
 
-	fmt.Println("Hang tight while we grab the latest from your chart repositories...")
+	fmt.Fprintln(out, "Hang tight while we grab the latest from your chart repositories...")
 	var wg sync.WaitGroup
+	var outLock sync.Mutex
 	for _, repository := range repos {
 		wg.Add(1)
 		go func(repository *repo.ChartRepository) {
 			defer wg.Done()
-			if _, err := repository.DownloadIndexFile(); err != nil {
-				fmt.Printf("Sad. Unable to get an update from the %q chart repository (%s):\n\t%s\n", repository.Config.Name, repository.Config.URL, err)
+			_, err := repository.DownloadIndexFile()
+
+			outLock.Lock()
+			defer outLock.Unlock()
+			if err != nil {
+				fmt.Fprintf(out, "Sad. Unable to get an update from the %q chart repository (%s):\n\t%s\n", repository.Config.Name, repository.Config.URL, err)
 			} else {
-				fmt.Printf("Yay! Successfully got an update from the %q chart repository\n", repository.Config.Name)
+				fmt.Fprintf(out, "Yay! Successfully got an update from the %q chart repository\n", repository.Config.Name)
 			}
 		}(repository)
 	}
 	wg.Wait()
-	fmt.Println("Alright! Update Complete. ⎈ Happy Helming! ⎈")
+	fmt.Fprintln(out, "Alright! Update Complete. ⎈ Happy Helming! ⎈")
 	return nil
 }
 
-// installChart perform a chart install
-func installChart(releaseName, repositoryName, chartName string, args map[string]string) error {
-	fmt.Printf("Install %s chart from %s repository...\n", chartName, repositoryName)
+// installChart perform a chart install, when the repositoryURL is set the chart is found directly in that repository instead of the added helm repositories
+func installChart(settings *cli.EnvSettings, releaseName, repositoryName, repositoryURL, chartName string, args map[string]string, valueLevels []values.Options, out io.Writer) (*release.Release, error) {
+	fmt.Fprintf(out, "Install %s chart from %s repository...\n", chartName, repositoryName)
 	actionConfig := new(action.Configuration)
-	err := actionConfig.Init(settings.RESTClientGetter(), settings.Namespace(), os.Getenv("HELM_DRIVER"), debug)
+	err := actionConfig.Init(restClientGetter(settings), settings.Namespace(), os.Getenv("HELM_DRIVER"), debug)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	client := action.NewInstall(actionConfig)
 
+	if args["dry-run"] == "true" {
+		client.DryRun = true
+		client.ClientOnly = true
+		client.IncludeCRDs = true
+	}
+
+	client.Version = args["version"]
+	client.Devel = args["devel"] == "true"
 	if client.Version == "" && client.Devel {
 		client.Version = ">0.0.0-0"
 	}
 
+	if !client.DryRun {
+		client.Replace, err = replaceRelease(actionConfig, releaseName)
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s namespace: %w", releaseName, settings.Namespace(), err)
+		}
+		if client.Replace {
+			fmt.Fprintf(out, "%s has a failed or uninstalled last revision, it is replaced\n", releaseName)
+		}
+	}
+
 	client.ReleaseName = releaseName
-	chartPath, err := client.ChartPathOptions.LocateChart(fmt.Sprintf("%s/%s", repositoryName, chartName), settings)
+	client.RepoURL = repositoryURL
+	chartRequested, vals, err := loadChart(settings, &client.ChartPathOptions, repositoryName, chartName, valueLevels, client.DependencyUpdate, out)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	getter.All(settings)
+	if !client.DryRun {
+		client.Description = versionDescription("Install", chartRequested, client.Version)
+	}
+
+	client.CreateNamespace = true
+	client.Namespace = settings.Namespace()
+	helmRelease, err := client.Run(chartRequested, vals)
 
-	p := getter.All(settings)
-	valueOpts := &values.Options{}
-	vals, err := valueOpts.MergeValues(p)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := strvals.ParseInto(args["set"], vals); err != nil {
-		return err
+	if client.DryRun {
+		fmt.Fprintf(out, "%s is rendered with %s chart version\n", helmRelease.Name, helmRelease.Chart.Metadata.Version)
+	} else {
+		fmt.Fprintf(out, "%s is deployed with %s chart version\n", helmRelease.Name, helmRelease.Chart.Metadata.Version)
+	}
+
+	return helmRelease, nil
+}
+
+// replaceRelease tell the install has to replace the history of the release like helm install --replace, it is true when the last revision is failed or uninstalled
+// ErrReleaseExists is returned when the release is installed
+func replaceRelease(actionConfig *action.Configuration, releaseName string) (bool, error) {
+	history, err := actionConfig.Releases.History(releaseName)
+	if errors.Is(err, driver.ErrReleaseNotFound) || (err == nil && len(history) == 0) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	releaseutil.Reverse(history, releaseutil.SortByRevision)
+	switch history[0].Info.Status {
+	case release.StatusFailed, release.StatusUninstalled:
+		return true, nil
+	}
+
+	return false, ErrReleaseExists
+}
+
+// upgradeChart perform a release upgrade
+func upgradeChart(settings *cli.EnvSettings, releaseName, repositoryName, chartName string, args map[string]string, valueLevels []values.Options, out io.Writer) (*release.Release, error) {
+	fmt.Fprintf(out, "Upgrade %s release with %s chart from %s repository...\n", releaseName, chartName, repositoryName)
+	actionConfig := new(action.Configuration)
+	err := actionConfig.Init(restClientGetter(settings), settings.Namespace(), os.Getenv("HELM_DRIVER"), debug)
+	if err != nil {
+		return nil, err
+	}
+
+	client := action.NewUpgrade(actionConfig)
+
+	client.Version = args["version"]
+	client.ReuseValues = args["reuse-values"] == "true"
+	client.ResetValues = args["reset-values"] == "true"
+	if client.ReuseValues && client.ResetValues {
+		return nil, errors.New("reuse-values and reset-values cannot be used together")
+	}
+
+	client.Devel = args["devel"] == "true"
+	if client.Version == "" && client.Devel {
+		client.Version = ">0.0.0-0"
+	}
+
+	chartRequested, vals, err := loadChart(settings, &client.ChartPathOptions, repositoryName, chartName, valueLevels, client.DependencyUpdate, out)
+	if err != nil {
+		return nil, err
+	}
+
+	client.Description = versionDescription("Upgrade", chartRequested, client.Version)
+
+	client.Namespace = settings.Namespace()
+	helmRelease, err := client.Run(releaseName, chartRequested, vals)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(out, "%s is upgraded to %s chart version, revision %d\n", helmRelease.Name, helmRelease.Chart.Metadata.Version, helmRelease.Version)
+
+	return helmRelease, nil
+}
+
+// versionDescription return the release description which record the resolved chart version and the requested version or constraint
+func versionDescription(operation string, chartRequested *chart.Chart, requestedVersion string) string {
+	if requestedVersion == "" {
+		requestedVersion = "latest"
+	}
+
+	return fmt.Sprintf("%s complete, chart version %s (requested %s)", operation, chartRequested.Metadata.Version, requestedVersion)
+}
+
+// MergeValues merge the values of the levels in order, a value of a later level override the same value of an earlier level
+// Inside every level the helm order is used: values files, set-json, set, set-string, set-file
+func MergeValues(valueLevels []values.Options, p getter.Providers) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+	for i := range valueLevels {
+		levelValues, err := valueLevels[i].MergeValues(p)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeMaps(merged, levelValues)
+	}
+
+	return merged, nil
+}
+
+// mergeMaps merge b into a copy of a like helm merge the values files, the nested maps are merged and the other values of b override the values of a
+func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if bv, ok := out[k]; ok {
+				if bv, ok := bv.(map[string]interface{}); ok {
+					out[k] = mergeMaps(bv, v)
+					continue
+				}
+			}
+		}
+		out[k] = v
+	}
+
+	return out
+}
+
+// loadChart locate and load the chart, check its dependencies and merge the values of the levels
+// The repository index is read under the read lock, so an other install can not update it at the same time
+func loadChart(settings *cli.EnvSettings, pathOptions *action.ChartPathOptions, repositoryName, chartName string, valueLevels []values.Options, dependencyUpdate bool, out io.Writer) (*chart.Chart, map[string]interface{}, error) {
+	repositoryLock.RLock()
+	defer repositoryLock.RUnlock()
+
+	chartReference := fmt.Sprintf("%s/%s", repositoryName, chartName)
+	if pathOptions.RepoURL != "" {
+		chartReference = chartName
+	}
+
+	chartPath, err := pathOptions.LocateChart(chartReference, settings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := getter.All(settings)
+	vals, err := MergeValues(valueLevels, p)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	chartRequested, err := loader.Load(chartPath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	validInstallableChart, err := isChartInstallable(chartRequested)
 	if !validInstallableChart {
-		return err
+		return nil, nil, err
 	}
 
 	if req := chartRequested.Metadata.Dependencies; req != nil {
 		if err := action.CheckDependencies(chartRequested, req); err != nil {
-			if client.DependencyUpdate {
+			if dependencyUpdate {
 				manager := &downloader.Manager{
-					Out:              os.Stdout,
+					Out:              out,
 					ChartPath:        chartPath,
-					Keyring:          client.ChartPathOptions.Keyring,
+					Keyring:          pathOptions.Keyring,
 					SkipUpdate:       false,
 					Getters:          p,
 					RepositoryConfig: settings.RepositoryConfig,
 					RepositoryCache:  settings.RepositoryCache,
 				}
 				if err := manager.Update(); err != nil {
-					return err
+					return nil, nil, err
 				}
 			} else {
-				return err
+				return nil, nil, err
 			}
 		}
 	}
 
-	client.CreateNamespace = true
-	client.Namespace = settings.Namespace()
-	release, err := client.Run(chartRequested, vals)
-
-	if err != nil {
-		return err
-	}
-	fmt.Printf("%s is deployed\n", release.Name)
-
-	return nil
+	return chartRequested, vals, nil
 }
 
 // uninstallChart perform a chart uninstall
-func uninstallChart(releaseName string) error {
-	fmt.Printf("Uninstall %s chart\n", releaseName)
+func uninstallChart(settings *cli.EnvSettings, releaseName string, out io.Writer) error {
+	fmt.Fprintf(out, "Uninstall %s chart\n", releaseName)
 	actionConfig := new(action.Configuration)
-	if err := actionConfig.Init(settings.RESTClientGetter(), settings.Namespace(), os.Getenv("HELM_DRIVER"), debug); err != nil {
+	if err := actionConfig.Init(restClientGetter(settings), settings.Namespace(), os.Getenv("HELM_DRIVER"), debug); err != nil {
 		return err
 	}
+
+	return uninstallRelease(actionConfig, releaseName, out)
+}
+
+// uninstallRelease uninstall the release, a release which is not installed is skipped, every other error is returned
+func uninstallRelease(actionConfig *action.Configuration, releaseName string, out io.Writer) error {
 	client := action.NewUninstall(actionConfig)
 
 	release, err := client.Run(releaseName)
-	if err != nil {
-		fmt.Printf("%s release not running.\n", releaseName)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		fmt.Fprintf(out, "%s release not running.\n", releaseName)
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
-	fmt.Printf("%s is uninstalled\n", release.Release.Name)
+	fmt.Fprintf(out, "%s is uninstalled\n", release.Release.Name)
 	return nil
 }
 
+// Resource is the kind, namespace and name of an object in a release manifest
+type Resource struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// String return the kind and name of the object like "Kind namespace/name"
+func (r Resource) String() string {
+	if r.Namespace == "" {
+		return fmt.Sprintf("%s %s", r.Kind, r.Name)
+	}
+
+	return fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name)
+}
+
+// ManifestObjects return every object of the release manifest in install order
+// The namespace is empty when it is not set in the manifest, these objects are in the release namespace
+func ManifestObjects(manifest string) ([]Resource, error) {
+	manifests := releaseutil.SplitManifests(manifest)
+
+	keys := []string{}
+	for key := range manifests {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	objects := []Resource{}
+	for _, key := range keys {
+		var head struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+		}
+
+		if err := yaml.Unmarshal([]byte(manifests[key]), &head); err != nil {
+			return nil, err
+		}
+		if head.Kind == "" {
+			continue
+		}
+
+		objects = append(objects, Resource{Kind: head.Kind, Namespace: head.Metadata.Namespace, Name: head.Metadata.Name})
+	}
+
+	return objects, nil
+}
+
+// ManifestResources return the kind and name of every object in the release manifest
+func ManifestResources(manifest string) ([]string, error) {
+	objects, err := ManifestObjects(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := []string{}
+	for _, object := range objects {
+		resources = append(resources, object.String())
+	}
+
+	return resources, nil
+}
+
+// CRDObjects return the custom resource definitions of the crds directory of the release chart, helm install them before the manifest
+func CRDObjects(helmRelease *release.Release) ([]Resource, error) {
+	objects := []Resource{}
+	if helmRelease.Chart == nil {
+		return objects, nil
+	}
+
+	for _, crd := range helmRelease.Chart.CRDObjects() {
+		crdObjects, err := ManifestObjects(string(crd.File.Data))
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, crdObjects...)
+	}
+
+	return objects, nil
+}
+
 // isChartInstallable check chart type is installable
 func isChartInstallable(chart *chart.Chart) (bool, error) {
 	switch chart.Metadata.Type {
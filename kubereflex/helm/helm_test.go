@@ -1,11 +1,26 @@
 package helm
 
 import (
+	"errors"
 	"flag"
+	stdio "io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli/values"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
 	"k8s.io/client-go/util/homedir"
 
 	"github.com/arpad-csepi/KLI/kubereflex/io"
@@ -54,66 +69,241 @@ func ChooseContextFromTestConfig(kubeconfig *string) string {
 	return contexts[0]
 }
 
-func TestSetSettings(t *testing.T) {
+func TestNewSettings(t *testing.T) {
 	getKubeConfig()
 	context := ChooseContextFromTestConfig(kubeconfig)
 
-	setSettings(testChart.namespace, kubeconfig, context)
+	clusterSettings := newSettings(testChart.namespace, kubeconfig, context)
+	otherSettings := newSettings("other-namespace", kubeconfig, "other-context")
 
-	if os.Getenv("HELM_NAMESPACE") != testChart.namespace {
-		t.Errorf("Helm namespace is incorrect")
-	}
-	if settings.Namespace() != testChart.namespace {
+	if clusterSettings.Namespace() != testChart.namespace {
 		t.Errorf("Kubernetes namespace is incorrect")
 	}
-	if settings.KubeConfig != *kubeconfig {
+	if clusterSettings.KubeConfig != *kubeconfig {
 		t.Errorf("Kubeconfig is incorrect")
 	}
+	if clusterSettings.KubeContext != context {
+		t.Errorf("Kube context is incorrect")
+	}
+	if otherSettings.Namespace() != "other-namespace" || otherSettings.KubeContext != "other-context" {
+		t.Errorf("Settings of different calls should be independent")
+	}
 }
 
 func TestInstall(t *testing.T) {
 	getKubeConfig()
 	context := ChooseContextFromTestConfig(kubeconfig)
 
-	clientConfig := map[string]string{
-		"kubeconfig": *kubeconfig,
+	clientset, err := kubectl.NewClientset(*kubeconfig, context)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	kubectl.CreateClient(clientConfig)
+	_ = RepositoryAdd(testChart.repositoryName, testChart.chartUrl, os.Stdout)
+	_ = clientset.CreateNamespace(testChart.namespace)
 
-	_ = RepositoryAdd(testChart.repositoryName, testChart.chartUrl)
-	kubectl.CreateNamespace(testChart.namespace)
-
-	err := Install(testChart.repositoryName, testChart.chartName, testChart.releaseName, testChart.namespace, testChart.arguments, kubeconfig, context)
+	_, err = Install(testChart.repositoryName, testChart.chartName, testChart.releaseName, testChart.namespace, testChart.arguments, nil, kubeconfig, context, os.Stdout)
 	if err != nil {
 		t.Error(err)
 	}
+
+	_, err = Install(testChart.repositoryName, testChart.chartName, testChart.releaseName, testChart.namespace, testChart.arguments, nil, kubeconfig, context, os.Stdout)
+	if !errors.Is(err, ErrReleaseExists) {
+		t.Errorf("Second install should return ErrReleaseExists, got: %v", err)
+	}
 }
 
 func TestUninstall(t *testing.T) {
 	getKubeConfig()
 	context := ChooseContextFromTestConfig(kubeconfig)
 
-	clientConfig := map[string]string{
-		"kubeconfig": *kubeconfig,
+	clientset, err := kubectl.NewClientset(*kubeconfig, context)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	kubectl.CreateClient(clientConfig)
+	_ = RepositoryAdd(testChart.repositoryName, testChart.chartUrl, os.Stdout)
+	_ = clientset.CreateNamespace(testChart.namespace)
+	_, _ = Install(testChart.repositoryName, testChart.chartName, testChart.releaseName, testChart.namespace, testChart.arguments, nil, kubeconfig, context, os.Stdout)
 
-	_ = RepositoryAdd(testChart.repositoryName, testChart.chartUrl)
-	kubectl.CreateNamespace(testChart.namespace)
-	_ = Install(testChart.repositoryName, testChart.chartName, testChart.releaseName, testChart.namespace, testChart.arguments, kubeconfig, context)
+	err = Uninstall(testChart.releaseName, testChart.namespace, kubeconfig, context, os.Stdout)
+	if err != nil {
+		t.Error(err)
+	}
+}
 
-	err := Uninstall(testChart.releaseName, testChart.namespace, kubeconfig, context)
+// failingDriver is a release storage which cannot read the releases, e.g. the secrets are forbidden
+type failingDriver struct {
+	*driver.Memory
+}
+
+func (failingDriver) Query(map[string]string) ([]*release.Release, error) {
+	return nil, errors.New("secrets is forbidden")
+}
+
+// newTestConfig return a helm action configuration with the release storage and without cluster
+func newTestConfig(releaseDriver driver.Driver) *action.Configuration {
+	return &action.Configuration{
+		Releases:     storage.Init(releaseDriver),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: stdio.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          func(string, ...interface{}) {},
+	}
+}
+
+func TestUninstallRelease(t *testing.T) {
+	err := uninstallRelease(newTestConfig(driver.NewMemory()), testChart.releaseName, stdio.Discard)
+	if err != nil {
+		t.Errorf("Not installed release should be skipped: %v", err)
+	}
+
+	err = uninstallRelease(newTestConfig(failingDriver{driver.NewMemory()}), testChart.releaseName, stdio.Discard)
+	if err == nil || !strings.Contains(err.Error(), "secrets is forbidden") {
+		t.Errorf("Storage error should be returned, got: %v", err)
+	}
+}
+
+func TestReplaceRelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []release.Status
+		replace  bool
+		err      error
+	}{
+		{name: "not installed"},
+		{name: "deployed", statuses: []release.Status{release.StatusDeployed}, err: ErrReleaseExists},
+		{name: "failed install", statuses: []release.Status{release.StatusFailed}, replace: true},
+		{name: "failed upgrade", statuses: []release.Status{release.StatusSuperseded, release.StatusFailed}, replace: true},
+		{name: "uninstalled", statuses: []release.Status{release.StatusUninstalled}, replace: true},
+		{name: "failed then deployed", statuses: []release.Status{release.StatusFailed, release.StatusDeployed}, err: ErrReleaseExists},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actionConfig := newTestConfig(driver.NewMemory())
+			for i, status := range test.statuses {
+				err := actionConfig.Releases.Create(&release.Release{
+					Name:      testChart.releaseName,
+					Namespace: testChart.namespace,
+					Version:   i + 1,
+					Info:      &release.Info{Status: status},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			replace, err := replaceRelease(actionConfig, testChart.releaseName)
+			if replace != test.replace || !errors.Is(err, test.err) {
+				t.Errorf("Expected %v replace with %v error, got %v with %v", test.replace, test.err, replace, err)
+			}
+		})
+	}
+
+	_, err := replaceRelease(newTestConfig(failingDriver{driver.NewMemory()}), testChart.releaseName)
+	if err == nil || !strings.Contains(err.Error(), "secrets is forbidden") {
+		t.Errorf("Storage error should be returned, got: %v", err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	getKubeConfig()
+	context := ChooseContextFromTestConfig(kubeconfig)
+
+	_ = RepositoryAdd(testChart.repositoryName, testChart.chartUrl, os.Stdout)
+	_, _ = Install(testChart.repositoryName, testChart.chartName, testChart.releaseName, testChart.namespace, testChart.arguments, nil, kubeconfig, context, os.Stdout)
+
+	args := map[string]string{"reuse-values": "true"}
+	release, err := Upgrade(testChart.repositoryName, testChart.chartName, testChart.releaseName, testChart.namespace, args, nil, kubeconfig, context, os.Stdout)
 	if err != nil {
 		t.Error(err)
 	}
+
+	if release != nil && release.Version < 2 {
+		t.Errorf("Release revision should be increased by the upgrade")
+	}
+
+	args = map[string]string{"reuse-values": "true", "reset-values": "true"}
+	_, err = Upgrade(testChart.repositoryName, testChart.chartName, testChart.releaseName, testChart.namespace, args, nil, kubeconfig, context, os.Stdout)
+	if err == nil {
+		t.Errorf("reuse-values and reset-values together should fail")
+	}
+
+	_ = Uninstall(testChart.releaseName, testChart.namespace, kubeconfig, context, os.Stdout)
+}
+
+func TestManifestResources(t *testing.T) {
+	manifest := "---\n# Source: chart/templates/serviceaccount.yaml\napiVersion: v1\nkind: ServiceAccount\nmetadata:\n  name: test-account\n  namespace: test-namespace\n" +
+		"---\napiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: test-role\n"
+
+	resources, err := ManifestResources(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resources) != 2 || resources[0] != "ServiceAccount test-namespace/test-account" || resources[1] != "ClusterRole test-role" {
+		t.Errorf("Wrong manifest resources: %v", resources)
+	}
+}
+
+func TestCRDObjects(t *testing.T) {
+	crds := "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: clusters.clusterregistry.k8s.cisco.com\n" +
+		"---\napiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: syncrules.clusterregistry.k8s.cisco.com\n"
+	helmRelease := &release.Release{Chart: &chart.Chart{
+		Metadata: &chart.Metadata{Name: "test-chart"},
+		Files:    []*chart.File{{Name: "crds/crds.yaml", Data: []byte(crds)}, {Name: "README.md"}},
+	}}
+
+	objects, err := CRDObjects(helmRelease)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(objects) != 2 || objects[0].Kind != "CustomResourceDefinition" || objects[1].Name != "syncrules.clusterregistry.k8s.cisco.com" {
+		t.Errorf("Wrong custom resource definitions: %v", objects)
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	// a comma in the path must not split the values file
+	valuesFile := filepath.Join(t.TempDir(), "values,chart.yaml")
+	err := os.WriteFile(valuesFile, []byte("replicas: 1\nimage:\n  tag: latest\n  pullPolicy: Always\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valueLevels := []values.Options{
+		{
+			ValueFiles:   []string{valuesFile},
+			JSONValues:   []string{`resources={"limits":{"cpu":"100m"}}`},
+			Values:       []string{"replicas=2"},
+			StringValues: []string{"image.tag=1.0"},
+		},
+		// the later level override the set values of the earlier level
+		{Values: []string{"replicas=3"}},
+	}
+
+	vals, err := MergeValues(valueLevels, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vals["replicas"] != int64(3) {
+		t.Errorf("Set value of the later level should override the earlier level: %v", vals["replicas"])
+	}
+	image := vals["image"].(map[string]interface{})
+	if image["tag"] != "1.0" || image["pullPolicy"] != "Always" {
+		t.Errorf("Set string value should override the values file and keep the other values: %v", vals["image"])
+	}
+	if vals["resources"] == nil {
+		t.Error("JSON value is not merged")
+	}
 }
 
 func TestIsRepositoryExists(t *testing.T) {
-	_ = RepositoryAdd(testChart.repositoryName, testChart.chartUrl)
+	_ = RepositoryAdd(testChart.repositoryName, testChart.chartUrl, os.Stdout)
 
-	exists, err := IsRepositoryExists("cluster-registry")
+	exists, err := IsRepositoryExists("cluster-registry", os.Stdout)
 	if exists != true {
 		t.Errorf("This repository should exists at this point")
 	}
@@ -122,7 +312,7 @@ func TestIsRepositoryExists(t *testing.T) {
 		t.Errorf("Error when repository check called: %s", err)
 	}
 
-	exists, err = IsRepositoryExists("this-repository-a-bit-sus")
+	exists, err = IsRepositoryExists("this-repository-a-bit-sus", os.Stdout)
 	if exists != false {
 		t.Errorf("This repository should not exists")
 	}
@@ -134,21 +324,90 @@ func TestIsRepositoryExists(t *testing.T) {
 }
 
 func TestRepositoryAdd(t *testing.T) {
-	err := RepositoryAdd(testChart.repositoryName, testChart.chartUrl)
+	err := RepositoryAdd(testChart.repositoryName, testChart.chartUrl, os.Stdout)
 	if err != nil {
 		t.Errorf("Error when RepositoryAdd called: %s", err)
 	}
 
-	err = RepositoryAdd("this-repository-a-bit-sus", "no-where")
+	err = RepositoryAdd("this-repository-a-bit-sus", "no-where", os.Stdout)
 	if err == nil {
 		t.Errorf("Error when RepositoryAdd called: %s", err)
 	}
 }
 
 func TestRepositoryUpdate(t *testing.T) {
-	err := RepositoryUpdate()
+	err := RepositoryUpdate(os.Stdout)
 
 	if err != nil {
 		t.Errorf("Repository update failed: %s", err)
 	}
 }
+
+func TestRender(t *testing.T) {
+	// the repository of the test chart is served from a local directory
+	repositoryDir := t.TempDir()
+	chartDir, err := chartutil.Create("demo", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	demoChart, err := loader.Load(chartDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = chartutil.Save(demoChart, repositoryDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(repositoryDir)))
+	defer server.Close()
+
+	index, err := repo.IndexDirectory(repositoryDir, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = index.WriteFile(filepath.Join(repositoryDir, "index.yaml"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helmDir := t.TempDir()
+	repositoryConfig := filepath.Join(helmDir, "repositories.yaml")
+	repositoryCache := filepath.Join(helmDir, "repository")
+	t.Setenv("HELM_REPOSITORY_CONFIG", repositoryConfig)
+	t.Setenv("HELM_REPOSITORY_CACHE", repositoryCache)
+	t.Setenv("HELM_CACHE_HOME", filepath.Join(helmDir, "cache"))
+
+	missingKubeconfig := filepath.Join(helmDir, "kubeconfig")
+	helmRelease, err := Render(server.URL, "demo-repository", "demo", "demo", "demo", nil, nil, &missingKubeconfig, "", stdio.Discard)
+	if err != nil {
+		t.Fatalf("Render failed: %s", err)
+	}
+	if helmRelease.Chart.Metadata.Version != demoChart.Metadata.Version {
+		t.Errorf("Expected %s chart version, got %s", demoChart.Metadata.Version, helmRelease.Chart.Metadata.Version)
+	}
+	if !strings.Contains(helmRelease.Manifest, "kind: Deployment") {
+		t.Errorf("Expected the rendered manifest, got %s", helmRelease.Manifest)
+	}
+
+	// the helm repositories are not changed by the render
+	for _, path := range []string{repositoryConfig, repositoryCache} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected no %s after render, got %v", path, err)
+		}
+	}
+}
+
+func TestVersionDescription(t *testing.T) {
+	chartRequested := &chart.Chart{Metadata: &chart.Metadata{Name: "test-chart", Version: "2.17.1"}}
+
+	description := versionDescription("Install", chartRequested, "~2.17.0")
+	if description != "Install complete, chart version 2.17.1 (requested ~2.17.0)" {
+		t.Errorf("Wrong description: %s", description)
+	}
+
+	description = versionDescription("Upgrade", chartRequested, "")
+	if description != "Upgrade complete, chart version 2.17.1 (requested latest)" {
+		t.Errorf("Wrong description: %s", description)
+	}
+}
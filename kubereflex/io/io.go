@@ -1,19 +1,26 @@
 package io
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	stdio "io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 
-	istio_operator "github.com/banzaicloud/istio-operator/api/v2/v1alpha1"
-
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
 
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 
-	api "github.com/kubernetes-client/go/kubernetes/config/api"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 func fileRead(path string) ([]byte, error) {
@@ -25,49 +32,140 @@ func fileRead(path string) ([]byte, error) {
 	return data, nil
 }
 
-func ReadYAMLResourceFile(path string) (client.Object, error) {
+// ReadYAMLResourceFile read every kubernetes object of a multi-document YAML or JSON file, the objects can be any kind
+// The empty documents are skipped and the items of a List are returned as separate objects, the order of the file is kept
+func ReadYAMLResourceFile(path string) ([]*unstructured.Unstructured, error) {
 	data, err := fileRead(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var icp istio_operator.IstioControlPlane
-	err = yaml.Unmarshal(data, &icp)
+	objects := []*unstructured.Unstructured{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for document := 1; ; document++ {
+		raw := runtime.RawExtension{}
+		err = decoder.Decode(&raw)
+		if errors.Is(err, stdio.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s document %d: %w", path, document, err)
+		}
+
+		raw.Raw = bytes.TrimSpace(raw.Raw)
+		if len(raw.Raw) == 0 || bytes.Equal(raw.Raw, []byte("null")) {
+			continue
+		}
+
+		object := &unstructured.Unstructured{}
+		err = object.UnmarshalJSON(raw.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s document %d: %w", path, document, err)
+		}
+		if object.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("%s document %d: apiVersion is not set", path, document)
+		}
+
+		if !object.IsList() {
+			objects = append(objects, object)
+			continue
+		}
+
+		list, err := object.ToList()
+		if err != nil {
+			return nil, fmt.Errorf("%s document %d: %w", path, document, err)
+		}
+		for i := range list.Items {
+			objects = append(objects, &list.Items[i])
+		}
+	}
 
-	if err != nil {
-		return nil, err
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("%s: no kubernetes object in the file", path)
 	}
-	return icp.DeepCopy(), nil
+
+	return objects, nil
 }
 
-func loadConfig(path string) (*api.Config, error) {
-	data, err := fileRead(path)
-	if err != nil {
-		return nil, err
+// Context is a context of the kubeconfig with the server of its cluster, its user and namespace
+type Context struct {
+	Name      string
+	Cluster   string
+	Server    string
+	User      string
+	Namespace string
+	// Current tell the context is the current-context of the kubeconfig
+	Current bool
+}
+
+// LoadingRules return the clientcmd loading rules of the kubeconfig
+// A path list (separated by colon like the KUBECONFIG environment variable) is merged, an empty kubeconfig means the KUBECONFIG environment variable or $HOME/.kube/config
+func LoadingRules(kubeconfig string) *clientcmd.ClientConfigLoadingRules {
+	if kubeconfig == "" {
+		return clientcmd.NewDefaultClientConfigLoadingRules()
 	}
 
-	c := api.Config{}
+	paths := filepath.SplitList(kubeconfig)
+	if len(paths) > 1 {
+		return &clientcmd.ClientConfigLoadingRules{Precedence: paths}
+	}
 
-	err = yaml.Unmarshal(data, &c)
+	return &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}
+}
+
+// loadConfig read the kubeconfig with the loading rules, so a path list is merged
+func loadConfig(kubeconfig string) (*clientcmdapi.Config, error) {
+	return LoadingRules(kubeconfig).Load()
+}
+
+// GetContexts return every context of the kubeconfig with its server, user and namespace
+// The current-context is the first (it is the default choice) and the others are in the order of their names
+func GetContexts(kubeconfig string) ([]Context, error) {
+	config, err := loadConfig(kubeconfig)
 	if err != nil {
 		return nil, err
 	}
 
-	return &c, nil
+	contexts := []Context{}
+	for name, context := range config.Contexts {
+		server := ""
+		if cluster, ok := config.Clusters[context.Cluster]; ok {
+			server = cluster.Server
+		}
+
+		contexts = append(contexts, Context{
+			Name:      name,
+			Cluster:   context.Cluster,
+			Server:    server,
+			User:      context.AuthInfo,
+			Namespace: context.Namespace,
+			Current:   name == config.CurrentContext,
+		})
+	}
+
+	sort.Slice(contexts, func(i, j int) bool {
+		if contexts[i].Current != contexts[j].Current {
+			return contexts[i].Current
+		}
+		return contexts[i].Name < contexts[j].Name
+	})
+
+	return contexts, nil
 }
 
+// GetContextsFromConfig return the context names of the kubeconfig, the current-context is the first
 func GetContextsFromConfig(path string) ([]string, error) {
-	config, err := loadConfig(path)
+	contexts, err := GetContexts(path)
 	if err != nil {
 		return nil, err
 	}
 
 	contextNameList := []string{}
-	for _, context := range config.Contexts {
+	for _, context := range contexts {
 		contextNameList = append(contextNameList, context.Name)
 	}
 
-	return contextNameList, err
+	return contextNameList, nil
 }
 
 func GetClusterCRD(url string) (client.Object, error) {
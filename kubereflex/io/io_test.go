@@ -2,33 +2,88 @@ package io
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 func TestReadYAMLResourceFile(t *testing.T) {
-	yaml_content := "kind: IstioControlPlane\nmetadata:\n  name: icp-v115x\n  namespace: istio-system"
+	yaml_content := "apiVersion: servicemesh.cisco.com/v1alpha1\nkind: IstioControlPlane\nmetadata:\n  name: icp-v115x\n  namespace: istio-system\n" +
+		"---\n---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: istio-system\n" +
+		"---\napiVersion: v1\nkind: List\nitems:\n- apiVersion: security.istio.io/v1beta1\n  kind: PeerAuthentication\n  metadata:\n    name: default\n    namespace: istio-system\n  spec:\n    mtls:\n      mode: STRICT\n"
 
 	err := os.WriteFile("test_resource.yaml", []byte(yaml_content), 0755)
     if err != nil {
         t.Errorf("Unable to write file: %v", err)
     }
 
-	object, err := ReadYAMLResourceFile("test_resource.yaml")
+	objects, err := ReadYAMLResourceFile("test_resource.yaml")
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Fatal(err)
+	}
+
+	if len(objects) != 3 {
+		t.Fatalf("Every document and list item should be an object, got: %d", len(objects))
 	}
 
-	if object == nil {
-		t.Errorf(err.Error())
+	kinds := []string{"IstioControlPlane", "Namespace", "PeerAuthentication"}
+	for i, object := range objects {
+		if object.GetKind() != kinds[i] || object.GetName() == "" {
+			t.Errorf("Object not properly converted: %v", object.Object)
+		}
 	}
 
-	if object.GetName() == "" || object.GetNamespace() == "" || object.GetObjectKind() == nil {
-		t.Errorf("Object not properly converted")
+	mode, _, _ := unstructured.NestedString(objects[2].Object, "spec", "mtls", "mode")
+	if mode != "STRICT" {
+		t.Errorf("Spec of the object not properly converted: %v", objects[2].Object)
 	}
 
 	os.Remove("test_resource.yaml")
 }
 
+func TestReadJSONResourceFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resource.json")
+	json_content := `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "istio-system"}}
+{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "mesh", "namespace": "istio-system"}, "data": {"replicas": "3"}}`
+
+	err := os.WriteFile(path, []byte(json_content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := ReadYAMLResourceFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(objects) != 2 || objects[0].GetKind() != "Namespace" || objects[1].GetNamespace() != "istio-system" {
+		t.Errorf("JSON objects not properly converted: %v", objects)
+	}
+}
+
+func TestReadYAMLResourceFileErrors(t *testing.T) {
+	contents := map[string]string{
+		"no kind":       "apiVersion: v1\nmetadata:\n  name: test\n",
+		"no apiVersion": "kind: Namespace\nmetadata:\n  name: test\n",
+		"no object":     "---\n# comment\n---\n",
+		"not YAML":      "kind: [Namespace\n",
+	}
+
+	for name, content := range contents {
+		path := filepath.Join(t.TempDir(), "resource.yaml")
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ReadYAMLResourceFile(path)
+		if err == nil {
+			t.Errorf("File with %s should return an error", name)
+		}
+	}
+}
+
 func TestGetClusterCRD(t *testing.T) {
 	url := "https://raw.githubusercontent.com/cisco-open/cluster-registry-controller/cb563ec383a6a98f8d8e5c79d3350997b7e70075/deploy/charts/cluster-registry/crds/clusterregistry.k8s.cisco.com_clusters.yaml"
 	clusterCRD, err := GetClusterCRD(url)
@@ -41,4 +96,84 @@ func TestGetClusterCRD(t *testing.T) {
 	if kind != "CustomResourceDefinition" {
 		t.Error("crd kind(a) bad")
 	}
-}
\ No newline at end of file
+}
+
+func TestGetContexts(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.yaml")
+	second := filepath.Join(dir, "second.yaml")
+
+	first_content := `apiVersion: v1
+kind: Config
+clusters:
+  - name: kind
+    cluster:
+      server: https://127.0.0.1:6443
+users:
+  - name: kind-admin
+    user:
+      token: test
+contexts:
+  - name: kind-kind
+    context:
+      cluster: kind
+      user: kind-admin
+current-context: kind-kind2
+`
+	second_content := `apiVersion: v1
+kind: Config
+clusters:
+  - name: kind2
+    cluster:
+      server: https://127.0.0.1:7443
+users:
+  - name: kind2-admin
+    user:
+      token: test
+contexts:
+  - name: kind-kind2
+    context:
+      cluster: kind2
+      user: kind2-admin
+      namespace: istio-system
+current-context: kind-kind
+`
+	for path, content := range map[string]string{first: first_content, second: second_content} {
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the current-context of the first file win
+	contexts, err := GetContexts(first + string(filepath.ListSeparator) + second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Context{
+		{Name: "kind-kind2", Cluster: "kind2", Server: "https://127.0.0.1:7443", User: "kind2-admin", Namespace: "istio-system", Current: true},
+		{Name: "kind-kind", Cluster: "kind", Server: "https://127.0.0.1:6443", User: "kind-admin"},
+	}
+	if len(contexts) != len(expected) {
+		t.Fatalf("Expected %d merged contexts, got: %v", len(expected), contexts)
+	}
+	for i := range expected {
+		if contexts[i] != expected[i] {
+			t.Errorf("Expected %v context, got: %v", expected[i], contexts[i])
+		}
+	}
+
+	names, err := GetContextsFromConfig(second + string(filepath.ListSeparator) + first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "kind-kind" || names[1] != "kind-kind2" {
+		t.Errorf("Expected the current-context first, got: %v", names)
+	}
+
+	_, err = GetContexts(filepath.Join(dir, "missing.yaml"))
+	if err == nil {
+		t.Errorf("Expected error for a missing kubeconfig")
+	}
+}
@@ -4,138 +4,240 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
+	"os"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/discovery/cached/memory"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/kubectl/pkg/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 
 	istio_operator "github.com/banzaicloud/istio-operator/api/v2/v1alpha1"
 	cluster_registry "github.com/cisco-open/cluster-registry-controller/api/v1alpha1"
+
+	kubeio "github.com/arpad-csepi/KLI/kubereflex/io"
 )
 
+// Clientset is the handle of one cluster, every kubernetes operation is a method of it
 type Clientset struct {
-	client    client.Client
+	client    client.WithWatch
 	config    *rest.Config
 	discovery *discovery.DiscoveryClient
+	// out is the writer of the progress messages about this cluster
+	out io.Writer
 }
 
 type clusterInfo struct {
-	restClient client.Client
-	secret     *corev1.Secret
-	cluster    *cluster_registry.Cluster
+	secret  *corev1.Secret
+	cluster *cluster_registry.Cluster
 }
 
-var ActiveClientset Clientset
-var clients []Clientset
+// ClusterIdentity is the name and namespace of the cluster-registry Cluster and Secret objects which belong to a cluster
+// The name is the configured name of the cluster, Attach and Detach discover the real name of the local Cluster object from the cluster
+type ClusterIdentity struct {
+	Name      string
+	Namespace string
+}
 
-// CreateClient set up kubernetes REST client which scheme contains custom kubernetes types from banzaicloud and cisco-open
-func CreateClient(c ...map[string]string) error {
-	if len(c) == 0 {
-		return errors.New("no kubeconfig was definied")
-	}
+// PairResult is the result of the attach or detach between two clusters
+type PairResult struct {
+	Source string
+	Target string
+	// Applied are the peer objects which are created or updated by attach on both clusters
+	Applied []PeerObject
+	Err     error
+}
 
-	for i := 0; i < len(c); i++ {
-		clientset := Clientset{}
-		var restConfig *rest.Config
-		var err error
+// PeerObject is a secret or cluster object of a peer which is applied on the cluster by attach
+type PeerObject struct {
+	Cluster string
+	Object  string
+	Result  ApplyResult
+}
 
-		if _, exist := c[i]["kubeconfig"]; !exist {
-			return errors.New("no kubeconfig was definied")
-		}
+// ClusterSummary count the peer objects of a cluster by the result of the attach
+type ClusterSummary struct {
+	Cluster   string
+	Created   int
+	Updated   int
+	Unchanged int
+}
 
-		// REST configuration for creating custom client
-		_, exist := c[i]["context"]
-		if exist {
-			restConfig, err = buildConfigFromFlags(c[i]["context"], c[i]["kubeconfig"])
-			if err != nil {
-				return err
-			}
-		} else {
-			restConfig, err = clientcmd.BuildConfigFromFlags("", c[i]["kubeconfig"])
-			if err != nil {
-				return err
-			}
-		}
+// ControlPlane is the summary of an IstioControlPlane object
+type ControlPlane struct {
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	Version      string `json:"version"`
+	Mode         string `json:"mode"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
 
-		clientset.config = restConfig
+// Peer is the summary of a cluster-registry Cluster object and its secret
+type Peer struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	State  string `json:"state"`
+	Secret bool   `json:"secret"`
+}
 
-		// discoverClient discover server-supported API groups, versions and resources.
-		discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
-		if err != nil {
-			return err
-		}
+// ErrContextNotFound is returned by NewClientset when the context is not in the kubeconfig
+var ErrContextNotFound = errors.New("context not found")
 
-		clientset.discovery = discoveryClient
+// ErrDeploymentNotReady is returned by Verify when a workload is not ready until the timeout or it is failed
+var ErrDeploymentNotReady = errors.New("workload is not ready")
 
-		// runtimeScheme contains already registered types in the API server
-		runtimeScheme := scheme.Scheme
+// ErrApplyConflict is returned by Apply when an other field manager own a field which is changed by the apply
+var ErrApplyConflict = errors.New("apply conflict")
 
-		apiextensionsv1.AddToScheme(runtimeScheme)
-		appsv1.AddToScheme(runtimeScheme)
+// ErrStuckTerminating is returned by Remove when a deleted object is still on the cluster after the timeout, e.g. its finalizers are not processed
+var ErrStuckTerminating = errors.New("object is stuck terminating")
 
-		// Add custom types to the runtime scheme
-		istio_operator.SchemeBuilder.AddToScheme(runtimeScheme)
-		cluster_registry.SchemeBuilder.AddToScheme(runtimeScheme)
+// ErrLocalClusterNotFound is the error of an Attach pair when the cluster-registry controller not created the Cluster object of the cluster itself until the timeout
+var ErrLocalClusterNotFound = errors.New("local cluster-registry Cluster not found")
 
-		// mapper initializes a mapping between Kind and APIVersion to a resource name and back based on the objects in a runtime.Scheme and the Kubernetes API conventions.
-		cachedDiscoveryClient := memory.NewMemCacheClient(discoveryClient)
-		cachedDiscoveryClient.Invalidate()
+var istioControlPlaneListKind = schema.GroupVersionKind{Group: "servicemesh.cisco.com", Version: "v1alpha1", Kind: "IstioControlPlaneList"}
+var clusterListKind = schema.GroupVersionKind{Group: "clusterregistry.k8s.cisco.com", Version: "v1alpha1", Kind: "ClusterList"}
 
-		mapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscoveryClient)
+// out is the writer of the progress messages
+var out io.Writer = os.Stdout
 
-		// restClient is the custom client which known the custom resource types
-		customClient, err := client.New(restConfig, client.Options{Scheme: runtimeScheme, Mapper: mapper, Opts: client.WarningHandlerOptions{}})
-		if err != nil {
-			return err
-		}
+// SetOutput set the writer of the progress messages, the default is the standard output
+// A new Clientset take the current writer, it can be changed per cluster with the SetOutput method of the Clientset
+func SetOutput(w io.Writer) {
+	out = w
+}
 
-		clientset.client = customClient
+// SetOutput set the writer of the progress messages about this cluster
+func (c *Clientset) SetOutput(w io.Writer) {
+	c.out = w
+}
 
-		clients = append(clients, clientset)
+// NewClientset set up kubernetes REST client which scheme contains custom kubernetes types from banzaicloud and cisco-open
+// The context is the current context of the kubeconfig when it is empty, the kubeconfig can be a path list like KUBECONFIG, the Clientset is safe for concurrent use
+func NewClientset(kubeconfig string, context string) (*Clientset, error) {
+	// REST configuration for creating custom client
+	restConfig, err := buildConfigFromFlags(context, kubeconfig)
+	if err != nil {
+		return nil, err
 	}
 
-	SetActiveClientset(clients[0])
+	// discoverClient discover server-supported API groups, versions and resources.
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	runtimeScheme, err := newScheme()
+	if err != nil {
+		return nil, err
+	}
+
+	// mapper initializes a mapping between Kind and APIVersion to a resource name and back based on the objects in a runtime.Scheme and the Kubernetes API conventions.
+	cachedDiscoveryClient := memory.NewMemCacheClient(discoveryClient)
+	cachedDiscoveryClient.Invalidate()
+
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscoveryClient)
+
+	// restClient is the custom client which known the custom resource types
+	customClient, err := client.NewWithWatch(restConfig, client.Options{Scheme: runtimeScheme, Mapper: mapper, Opts: client.WarningHandlerOptions{}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Clientset{
+		client:    customClient,
+		config:    restConfig,
+		discovery: discoveryClient,
+		out:       out,
+	}, nil
+}
+
+// newScheme return a new runtime scheme with the built-in and the custom types, every Clientset has its own scheme so they not share state
+func newScheme() (*runtime.Scheme, error) {
+	runtimeScheme := runtime.NewScheme()
+
+	addToScheme := []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme,
+		apiextensionsv1.AddToScheme,
+		// Add custom types to the runtime scheme
+		istio_operator.SchemeBuilder.AddToScheme,
+		cluster_registry.SchemeBuilder.AddToScheme,
+	}
+	for _, add := range addToScheme {
+		err := add(runtimeScheme)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return runtimeScheme, nil
 }
 
-// TODO: Maybe need more elegant solution, but now is just fine
-func RemoveAllClients() {
-	clients = []Clientset{}
+// Ping check the API server of the context answer a discovery request until the timeout, the error tell why it is not reachable
+func Ping(kubeconfig string, context string, timeout time.Duration) error {
+	restConfig, err := buildConfigFromFlags(context, kubeconfig)
+	if err != nil {
+		return err
+	}
+	restConfig.Timeout = timeout
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	_, err = discoveryClient.ServerVersion()
+	return err
 }
 
+// buildConfigFromFlags return the REST config of the context, a kubeconfig path list is merged and an empty kubeconfig use KUBECONFIG, ErrContextNotFound is returned when the kubeconfig has no such context
 func buildConfigFromFlags(context string, kubeconfigPath string) (*rest.Config, error) {
-	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		kubeio.LoadingRules(kubeconfigPath),
 		&clientcmd.ConfigOverrides{
 			CurrentContext: context,
-		}).ClientConfig()
-}
+		})
 
-func SetActiveClientset(newClientset Clientset) {
-	ActiveClientset = newClientset
+	if context != "" {
+		rawConfig, err := clientConfig.RawConfig()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := rawConfig.Contexts[context]; !exists {
+			return nil, fmt.Errorf("%s in %s: %w", context, kubeconfigPath, ErrContextNotFound)
+		}
+	}
+
+	return clientConfig.ClientConfig()
 }
 
 // CreateNamespace create namespace to provided kubeconfig kubecontext
-func CreateNamespace(namespace string) error {
+func (c *Clientset) CreateNamespace(namespace string) error {
 	ns := &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: namespace,
 		},
 	}
 
-	err := ActiveClientset.client.Create(context.Background(), ns, &client.CreateOptions{})
+	err := c.client.Create(context.Background(), ns, &client.CreateOptions{})
 	if err != nil {
 		return err
 	}
@@ -143,13 +245,13 @@ func CreateNamespace(namespace string) error {
 	return nil
 }
 
-func GetNamespace(namespace string) (*corev1.Namespace, error) {
+func (c *Clientset) GetNamespace(namespace string) (*corev1.Namespace, error) {
 	ns := &corev1.Namespace{}
 	key := types.NamespacedName{
 		Name: namespace,
 	}
 
-	err := ActiveClientset.client.Get(context.TODO(), key, ns, &client.GetOptions{})
+	err := c.client.Get(context.TODO(), key, ns, &client.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -157,13 +259,13 @@ func GetNamespace(namespace string) (*corev1.Namespace, error) {
 	return ns, nil
 }
 
-func DeleteNamespace(namespace string) error {
-	ns, err := GetNamespace(namespace)
+func (c *Clientset) DeleteNamespace(namespace string) error {
+	ns, err := c.GetNamespace(namespace)
 	if err != nil {
 		return err
 	}
 
-	err = ActiveClientset.client.Delete(context.TODO(), ns, &client.DeleteOptions{})
+	err = c.client.Delete(context.TODO(), ns, &client.DeleteOptions{})
 	if err != nil {
 		return err
 	}
@@ -172,10 +274,10 @@ func DeleteNamespace(namespace string) error {
 }
 
 // IsNamespaceExists check the given namespace is exists already or not
-func IsNamespaceExists(namespace string) (bool, error) {
+func (c *Clientset) IsNamespaceExists(namespace string) (bool, error) {
 	nsList := &corev1.NamespaceList{}
 
-	err := ActiveClientset.client.List(context.TODO(), nsList, &client.ListOptions{})
+	err := c.client.List(context.TODO(), nsList, &client.ListOptions{})
 	if err != nil {
 		return false, err
 	}
@@ -189,77 +291,122 @@ func IsNamespaceExists(namespace string) (bool, error) {
 	return false, nil
 }
 
-// Verify check release status until the given time
-func Verify(deploymentName string, namespace string, timeout time.Duration) error {
-	animation := [7]string{"_", "-", "`", "'", "´", "-", "_"}
-	frame := 0
-
+// IsDeploymentReady check the deployment readiness once in the same way as Verify
+func (c *Clientset) IsDeploymentReady(deploymentName string, namespace string) (bool, error) {
 	key := types.NamespacedName{
 		Namespace: namespace,
 		Name:      deploymentName,
 	}
 
-	for start := time.Now(); ; {
-		fmt.Printf("Verifing the %s deployment: [%s]", deploymentName, animation[frame])
-
-		deployment := &appsv1.Deployment{}
-		err := ActiveClientset.client.Get(context.TODO(), key, deployment, &client.GetOptions{})
-		if err != nil {
-			return err
-		}
-		if deployment.Status.Replicas == deployment.Status.ReadyReplicas {
-			fmt.Println("\nOk! Verify process was successful!")
-			break
-		}
-		if time.Since(start) > timeout {
-			fmt.Println("\nAww. One or more resource is not ready! Please check your cluster to more info.")
-			return errors.New("resources are not ready")
-		}
-		time.Sleep(150 * time.Millisecond)
-		fmt.Print("\033[G")
-		if frame == 6 {
-			frame = 0
-		} else {
-			frame += 1
-		}
+	deployment := &appsv1.Deployment{}
+	err := c.client.Get(context.TODO(), key, deployment, &client.GetOptions{})
+	if err != nil {
+		return false, err
 	}
 
-	return nil
+	return deploymentReadiness(deployment).Ready, nil
 }
 
-// Apply is read the custom resource definition and apply it with custom REST client
-func Apply(CRObject client.Object) error {
-	fmt.Printf("Apply %s resource file to %s namespace\n", CRObject.GetName(), CRObject.GetNamespace())
+// FieldManager is the field manager of the server-side apply, the fields which are set by KLI are owned by it
+const FieldManager = "KLI"
 
-	NamespacedClient := client.NewNamespacedClient(ActiveClientset.client, CRObject.GetNamespace())
+// ApplyResult tell what the server-side apply did with the object
+type ApplyResult string
+
+const (
+	ApplyCreated    ApplyResult = "created"
+	ApplyConfigured ApplyResult = "configured"
+	ApplyUnchanged  ApplyResult = "unchanged"
+)
 
-	err := NamespacedClient.Create(context.TODO(), CRObject)
+// Apply is create or update the object with server-side apply, so it can be applied again after the object file is changed
+// When an other field manager own a changed field ErrApplyConflict is returned, with force the fields are taken over
+func (c *Clientset) Apply(CRObject client.Object, force bool) (ApplyResult, error) {
+	applyObject, err := c.applyConfiguration(CRObject)
 	if err != nil {
-		return err
+		return "", err
 	}
-	fmt.Printf("Yep, %s resource applied\n", CRObject.GetName())
+	description := objectDescription(applyObject)
 
-	return nil
-}
+	fmt.Fprintf(c.out, "Apply %s\n", description)
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(applyObject.GroupVersionKind())
+	err = c.client.Get(context.TODO(), client.ObjectKeyFromObject(applyObject), existing)
+
+	result := ApplyConfigured
+	if apierrors.IsNotFound(err) {
+		result = ApplyCreated
+	} else if err != nil {
+		return "", err
+	}
+
+	options := []client.PatchOption{client.FieldOwner(FieldManager)}
+	if force {
+		options = append(options, client.ForceOwnership)
+	}
 
-// Remove is read the custom resource definition and remove it with custom REST client
-func Remove(CRObject client.Object) error {
-	fmt.Printf("Remove resource based on %s\n", CRObject.GetName())
+	err = c.client.Patch(context.TODO(), applyObject, client.Apply, options...)
+	if apierrors.IsConflict(err) {
+		return "", fmt.Errorf("%s: %s: %w", description, err, ErrApplyConflict)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if result == ApplyConfigured && applyObject.GetResourceVersion() == existing.GetResourceVersion() {
+		result = ApplyUnchanged
+	}
 
-	NamespacedClient := client.NewNamespacedClient(ActiveClientset.client, CRObject.GetNamespace())
+	fmt.Fprintf(c.out, "Yep, %s %s\n", description, result)
 
-	err := NamespacedClient.DeleteAllOf(context.TODO(), CRObject)
+	return result, nil
+}
+
+// applyConfiguration return the object as unstructured apply configuration without the fields which are set by the server
+// The fields of an object which is copied from an other cluster (uid, resource version, managed fields) would break the apply
+func (c *Clientset) applyConfiguration(object client.Object) (*unstructured.Unstructured, error) {
+	gvk, err := apiutil.GVKForObject(object, c.client.Scheme())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Println("Resource deleted!")
-	return nil
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, err
+	}
+
+	applyObject := &unstructured.Unstructured{Object: content}
+	applyObject.SetGroupVersionKind(gvk)
+	applyObject.SetUID("")
+	applyObject.SetResourceVersion("")
+	applyObject.SetGeneration(0)
+	applyObject.SetManagedFields(nil)
+	unstructured.RemoveNestedField(applyObject.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(applyObject.Object, "status")
+
+	return applyObject, nil
+}
+
+// objectDescription return the kind and name of the object like "Kind namespace/name"
+func objectDescription(object client.Object) string {
+	kind := object.GetObjectKind().GroupVersionKind().Kind
+	if object.GetNamespace() == "" {
+		return fmt.Sprintf("%s %s", kind, object.GetName())
+	}
+
+	return fmt.Sprintf("%s %s/%s", kind, object.GetNamespace(), object.GetName())
+}
+
+// Remove delete exactly the object with the name and namespace of the object, an object which is not on the cluster is skipped
+// With wait of the options the object has to be gone until the timeout, otherwise ErrStuckTerminating is returned
+func (c *Clientset) Remove(CRObject client.Object, options RemoveOptions) error {
+	return c.remove([]client.Object{CRObject}, options)
 }
 
 // GetAPIServerEndpoint is return with the API endpoint URL address
-func GetAPIServerEndpoint() (string, error) {
-	endpoint := ActiveClientset.discovery.RESTClient().Get().URL()
+func (c *Clientset) GetAPIServerEndpoint() (string, error) {
+	endpoint := c.discovery.RESTClient().Get().URL()
 	_, err := url.ParseRequestURI(endpoint.String())
 	if err != nil {
 		return "", err
@@ -267,147 +414,397 @@ func GetAPIServerEndpoint() (string, error) {
 	return endpoint.Host, nil
 }
 
-// GetDeploymentName is search the deployment name based on the chart release name
-func GetDeploymentName(releaseName string, namespace string) (string, error) {
-	deployments := &appsv1.DeploymentList{}
+// Attach is get the secret and cluster objects of every cluster and create or update them on every other cluster so can sync after that
+// The identities belong to the clientsets with the same index, the failure of a pair is in its result
+func Attach(clientsets []*Clientset, identities []ClusterIdentity) ([]PairResult, error) {
+	if len(identities) != len(clientsets) {
+		return nil, fmt.Errorf("got %d cluster identities for %d clients", len(identities), len(clientsets))
+	}
 
-	NamespacedClient := client.NewNamespacedClient(ActiveClientset.client, namespace)
-	err := NamespacedClient.List(context.TODO(), deployments, &client.ListOptions{})
-	if err != nil {
-		return "", err
+	fmt.Fprintln(out, "Attach process started")
+
+	fmt.Fprintln(out, "Get some info from clusters")
+	infos := make([]clusterInfo, len(clientsets))
+	infoErrors := make([]error, len(clientsets))
+	for i, identity := range identities {
+		key := identity.objectKey()
+		key.Name, infoErrors[i] = clientsets[i].localClusterName(identity, clusterInfoTimeout)
+		if infoErrors[i] == nil {
+			infos[i], infoErrors[i] = clientsets[i].getClusterInfo(key, clusterInfoTimeout)
+		}
 	}
 
-	for _, deployment := range deployments.Items {
-		for _, value := range deployment.Annotations {
-			if value == releaseName {
-				return deployment.Name, nil
+	fmt.Fprintln(out, "Sync resources between clusters")
+	results := []PairResult{}
+	for i := 0; i < len(clientsets); i++ {
+		for j := i + 1; j < len(clientsets); j++ {
+			result := PairResult{Source: identities[i].Name, Target: identities[j].Name}
+
+			if infoErrors[i] != nil {
+				result.Err = fmt.Errorf("%s: %w", identities[i].Name, infoErrors[i])
+			} else if infoErrors[j] != nil {
+				result.Err = fmt.Errorf("%s: %w", identities[j].Name, infoErrors[j])
+			} else {
+				appliedOnSource, sourceErr := clientsets[i].attachPeer(identities[i], infos[j])
+				appliedOnTarget, targetErr := clientsets[j].attachPeer(identities[j], infos[i])
+				result.Applied = append(appliedOnSource, appliedOnTarget...)
+				result.Err = errors.Join(sourceErr, targetErr)
 			}
+
+			results = append(results, result)
 		}
 	}
 
-	err = errors.New("deployment not found")
-	return "", err
+	fmt.Fprintln(out, "Attach completed!")
+	return results, nil
 }
 
-// Attach is get the secret and cluster objects and create on the another cluster so can sync after that
-func Attach(namespace1 string, namespace2 string) error {
-	fmt.Println("Attach process started")
+// Detach is delete the secret and cluster objects of every other cluster from every cluster so break the sync after that
+// The identities belong to the clientsets with the same index
+func Detach(clientsets []*Clientset, identities []ClusterIdentity) ([]PairResult, error) {
+	if len(identities) != len(clientsets) {
+		return nil, fmt.Errorf("got %d cluster identities for %d clients", len(identities), len(clientsets))
+	}
+
+	fmt.Fprintln(out, "Detach process started!")
+
+	fmt.Fprintln(out, "Get clusters and secrets info, please wait...")
+	// the peers are removed by the name of their local Cluster object, the configured name is used when it can not be found
+	peers := make([]ClusterIdentity, len(identities))
+	for i, identity := range identities {
+		peers[i] = identity
+		name, err := clientsets[i].localClusterName(identity, 0)
+		if err == nil {
+			peers[i].Name = name
+		}
+	}
+
+	results := []PairResult{}
+	for i := 0; i < len(clientsets); i++ {
+		for j := i + 1; j < len(clientsets); j++ {
+			results = append(results, PairResult{
+				Source: identities[i].Name,
+				Target: identities[j].Name,
+				Err: errors.Join(clientsets[i].removePeer(identities[i], peers[j]),
+					clientsets[j].removePeer(identities[j], peers[i])),
+			})
+		}
+	}
 
-	objectKey1 := client.ObjectKey{Namespace: namespace1, Name: "demo-active"}
-	objectKey2 := client.ObjectKey{Namespace: namespace2, Name: "demo-passive"}
+	fmt.Fprintln(out, "Cluster or secret objects are removed.\nDetach completed!")
+	return results, nil
+}
 
-	NamespacedClient1 := client.NewNamespacedClient(clients[0].client, namespace1)
-	NamespacedClient2 := client.NewNamespacedClient(clients[1].client, namespace2)
+// attachPeer create or update the secret and cluster object of the peer on the cluster, every object is applied even when an other one failed
+func (c *Clientset) attachPeer(identity ClusterIdentity, peer clusterInfo) ([]PeerObject, error) {
+	applied := []PeerObject{}
+	errs := []error{}
 
-	fmt.Println("Get some info from clusters")
-	cluster1Info, err := getClusterInfo(NamespacedClient1, objectKey1)
+	for _, object := range []client.Object{peer.secretFor(identity.Namespace), peer.clusterCopy()} {
+		gvk, err := apiutil.GVKForObject(object, c.client.Scheme())
+		if err != nil {
+			return applied, err
+		}
+		object.GetObjectKind().SetGroupVersionKind(gvk)
+
+		// the peer objects are copies which are owned by KLI, so the fields of an other field manager are taken over
+		result, err := c.Apply(object, true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s on %s: %w", objectDescription(object), identity.Name, err))
+			continue
+		}
+
+		applied = append(applied, PeerObject{Cluster: identity.Name, Object: objectDescription(object), Result: result})
+	}
+
+	return applied, errors.Join(errs...)
+}
+
+// Summarize count the applied peer objects of the attach results per cluster in the order of the results
+func Summarize(results []PairResult) []ClusterSummary {
+	summaries := []ClusterSummary{}
+	index := map[string]int{}
+
+	for _, result := range results {
+		for _, object := range result.Applied {
+			i, ok := index[object.Cluster]
+			if !ok {
+				i = len(summaries)
+				index[object.Cluster] = i
+				summaries = append(summaries, ClusterSummary{Cluster: object.Cluster})
+			}
+
+			switch object.Result {
+			case ApplyCreated:
+				summaries[i].Created++
+			case ApplyConfigured:
+				summaries[i].Updated++
+			case ApplyUnchanged:
+				summaries[i].Unchanged++
+			}
+		}
+	}
+
+	return summaries
+}
+
+// removePeer is delete the cluster and secret objects of the peer from the cluster
+// Nothing is removed when the objects or the Cluster CRD not exist, every other error is returned
+func (c *Clientset) removePeer(identity ClusterIdentity, peer ClusterIdentity) error {
+	peerInfo, err := c.getClusterInfo(client.ObjectKey{Namespace: identity.Namespace, Name: peer.Name}, 0)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		fmt.Fprintf(c.out, "%s not here on the %s cluster.\n", peer.Name, identity.Name)
+		return nil
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("%s on %s: %w", peer.Name, identity.Name, err)
 	}
 
-	cluster2Info, err := getClusterInfo(NamespacedClient2, objectKey2)
+	err = c.Remove(peerInfo.cluster, RemoveOptions{})
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Sync resources between clusters")
-	SetActiveClientset(clients[0])
-	Apply(cluster2Info.secret)
-	Apply(cluster2Info.cluster)
-	SetActiveClientset(clients[1])
-	Apply(cluster1Info.secret)
-	Apply(cluster1Info.cluster)
+	return c.Remove(peerInfo.secret, RemoveOptions{})
+}
 
-	fmt.Println("Attach completed!")
-	return nil
+func (identity ClusterIdentity) objectKey() client.ObjectKey {
+	return client.ObjectKey{Namespace: identity.Namespace, Name: identity.Name}
 }
 
-// Detach is get the secret and cluster objects and delete on the another cluster so break the sync after that
-func Detach(namespace1 string, namespace2 string) error {
-	fmt.Println("Detach process started!")
+// secretFor is return a copy of the secret which can be created in the given namespace
+// The owner references point to objects of the other cluster, so they are removed
+func (info clusterInfo) secretFor(namespace string) *corev1.Secret {
+	secret := info.secret.DeepCopy()
+	secret.Namespace = namespace
+	secret.OwnerReferences = nil
+	return secret
+}
 
-	objectKey1 := client.ObjectKey{Namespace: namespace1, Name: "demo-active"}
-	objectKey2 := client.ObjectKey{Namespace: namespace2, Name: "demo-passive"}
+// clusterCopy is return a copy of the cluster object which can be created on an other cluster
+func (info clusterInfo) clusterCopy() *cluster_registry.Cluster {
+	cluster := info.cluster.DeepCopy()
+	cluster.OwnerReferences = nil
+	return cluster
+}
 
-	NamespacedClient1 := client.NewNamespacedClient(clients[0].client, namespace1)
-	NamespacedClient2 := client.NewNamespacedClient(clients[1].client, namespace2)
+// GetControlPlanes is list the IstioControlPlane objects from every namespace
+func (c *Clientset) GetControlPlanes() ([]ControlPlane, error) {
+	icpList, err := c.listUnstructured(istioControlPlaneListKind, "")
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Println("Get clusters and secrets info, please wait...")
+	controlPlanes := []ControlPlane{}
+	for _, icp := range icpList.Items {
+		controlPlane := ControlPlane{
+			Name:      icp.GetName(),
+			Namespace: icp.GetNamespace(),
+		}
+		controlPlane.Version, _, _ = unstructured.NestedString(icp.Object, "spec", "version")
+		controlPlane.Mode, _, _ = unstructured.NestedString(icp.Object, "spec", "mode")
+		controlPlane.Status, _, _ = unstructured.NestedString(icp.Object, "status", "status")
+		controlPlane.ErrorMessage, _, _ = unstructured.NestedString(icp.Object, "status", "errorMessage")
 
-	SetActiveClientset(clients[0])
-	// TODO: Make a better struct for more compact code
-	cluster1Info, err1 := getClusterInfo(NamespacedClient1, objectKey1)
-	if err1 != nil {
-		fmt.Printf("%s not here on the main cluster.\n", objectKey1.Name)
-	} else {
-		Remove(cluster1Info.cluster)
-		Remove(cluster1Info.secret)
+		controlPlanes = append(controlPlanes, controlPlane)
 	}
-	cluster1Info2, err2 := getClusterInfo(NamespacedClient1, objectKey2)
-	if err2 != nil {
-		fmt.Printf("%s not here on the main cluster.\n", objectKey2.Name)
-	} else {
-		Remove(cluster1Info2.cluster)
-		Remove(cluster1Info2.secret)
+
+	return controlPlanes, nil
+}
+
+// GetPeers is list the cluster-registry Cluster objects and check their secret in the given namespace
+func (c *Clientset) GetPeers(namespace string) ([]Peer, error) {
+	clusterList, err := c.listUnstructured(clusterListKind, "")
+	if err != nil {
+		return nil, err
 	}
 
-	SetActiveClientset(clients[1])
-	cluster2Info, err3 := getClusterInfo(NamespacedClient2, objectKey1)
-	if err3 != nil {
-		fmt.Printf("%s not here on the secondary cluster.\n", objectKey1.Name)
-	} else {
-		Remove(cluster2Info.cluster)
-		Remove(cluster2Info.secret)
+	peers := []Peer{}
+	for _, cluster := range clusterList.Items {
+		peer := Peer{
+			Name: cluster.GetName(),
+		}
+		peer.Type, _, _ = unstructured.NestedString(cluster.Object, "status", "type")
+		peer.State, _, _ = unstructured.NestedString(cluster.Object, "status", "state")
+
+		secret := &corev1.Secret{}
+		err := c.client.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: peer.Name}, secret)
+		if err == nil {
+			peer.Secret = true
+		} else if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+
+		peers = append(peers, peer)
 	}
-	cluster2Info2, err4 := getClusterInfo(NamespacedClient2, objectKey2)
-	if err4 != nil {
-		fmt.Printf("%s not here on the secondary cluster.\n", objectKey2.Name)
-	} else {
-		Remove(cluster2Info2.cluster)
-		Remove(cluster2Info2.secret)
+
+	return peers, nil
+}
+
+// listUnstructured is list the objects of the given kind, a kind which is not installed on the cluster result an empty list
+func (c *Clientset) listUnstructured(listKind schema.GroupVersionKind, namespace string) (*unstructured.UnstructuredList, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(listKind)
+
+	err := c.client.List(context.TODO(), list, client.InNamespace(namespace))
+	if meta.IsNoMatchError(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("Cluster or secret objects are removed.\nDetach completed!")
-	return nil
+	return list, nil
 }
 
-// getClusterInfo is return the secret and cluster object from the given REST client cluster
-func getClusterInfo(clientset client.Client, objectKey client.ObjectKey) (clusterInfo, error) {
-	clusterInfoObj := clusterInfo{
-		restClient: nil,
-		secret:     &corev1.Secret{},
-		cluster:    &cluster_registry.Cluster{},
+// localClusterName find the name of the Cluster object which describe the cluster itself, it is created by the cluster-registry controller
+// The Cluster objects are listed and then watched from the list until the timeout when the local one is not created yet
+// When the watch can not be started or it is closed, they are listed again after a growing backoff, with zero timeout they are read once
+func (c *Clientset) localClusterName(identity ClusterIdentity, timeout time.Duration) (string, error) {
+	kubeSystem := &corev1.Namespace{}
+	err := c.client.Get(context.TODO(), client.ObjectKey{Name: metav1.NamespaceSystem}, kubeSystem)
+	if err != nil {
+		return "", err
 	}
-	var timeout = 3
+	clusterID := string(kubeSystem.UID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	backoff := watchBackoff
 
 	for {
-		if clusterInfoObj.secret.CreationTimestamp.IsZero() {
-			err := clientset.Get(context.TODO(), objectKey, clusterInfoObj.secret, &client.GetOptions{})
-			if err != nil {
-				return clusterInfoObj, err
-			}
+		clusterList, err := c.listUnstructured(clusterListKind, "")
+		if err != nil {
+			return "", err
+		}
+		clusters := clusterList.Items
+
+		name := localClusterOf(clusters, clusterID, identity.Name)
+		if name == "" && timeout > 0 {
+			watchList := &unstructured.UnstructuredList{}
+			watchList.SetGroupVersionKind(clusterListKind)
+
+			// the listed Clusters are kept up to date by the events, so the fallbacks of localClusterOf see every Cluster
+			// the watch only return the context error, it is reported as not found below
+			_, _ = c.watchUntil(ctx, client.ObjectKey{}, watchList, clusterList.GetResourceVersion(), func(eventType watch.EventType, object client.Object) bool {
+				content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+				if err != nil {
+					return false
+				}
+
+				clusters = updateClusters(clusters, eventType, &unstructured.Unstructured{Object: content})
+				name = localClusterOf(clusters, clusterID, identity.Name)
+				return name != ""
+			})
 		}
 
-		if clusterInfoObj.cluster.CreationTimestamp.IsZero() {
-			err := clientset.Get(context.TODO(), objectKey, clusterInfoObj.cluster)
-			if err != nil {
-				return clusterInfoObj, err
+		if name != "" {
+			if name != identity.Name {
+				fmt.Fprintf(c.out, "The local Cluster object of %s is %s\n", identity.Name, name)
 			}
+			return name, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("%s after %s: %w", identity.Name, timeout, ErrLocalClusterNotFound)
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+// updateClusters apply the watch event of a Cluster to the listed Clusters, the order of the list is kept
+func updateClusters(clusters []unstructured.Unstructured, eventType watch.EventType, cluster *unstructured.Unstructured) []unstructured.Unstructured {
+	for i := range clusters {
+		if clusters[i].GetName() != cluster.GetName() {
+			continue
+		}
+		if eventType == watch.Deleted {
+			return append(clusters[:i:i], clusters[i+1:]...)
+		}
+		clusters[i] = *cluster
+		return clusters
+	}
+
+	if eventType == watch.Deleted {
+		return clusters
+	}
+	return append(clusters, *cluster)
+}
+
+// localClusterOf return the name of the Cluster which cluster ID is the UID of the kube-system namespace
+// When no cluster ID match, the Cluster with Local status type is used, then the Cluster with the configured name
+// An empty name is returned when there is no local Cluster
+func localClusterOf(clusters []unstructured.Unstructured, clusterID string, configuredName string) string {
+	local := ""
+	named := ""
+	for _, cluster := range clusters {
+		id, _, _ := unstructured.NestedString(cluster.Object, "spec", "clusterID")
+		if id != "" && id == clusterID {
+			return cluster.GetName()
+		}
+
+		clusterType, _, _ := unstructured.NestedString(cluster.Object, "status", "type")
+		if clusterType == "Local" && local == "" {
+			local = cluster.GetName()
+		}
+		if cluster.GetName() == configuredName {
+			named = configuredName
+		}
+	}
+
+	if local != "" {
+		return local
+	}
+
+	return named
+}
+
+// clusterInfoTimeout is the wait for the secret and cluster objects on attach, the cluster-registry controller create them after it is started
+var clusterInfoTimeout = 30 * time.Second
+
+// getClusterInfo is return the secret and cluster object with the given key from the cluster
+// They are watched until the timeout when they are not created yet, with zero timeout they are read once
+func (c *Clientset) getClusterInfo(objectKey client.ObjectKey, timeout time.Duration) (clusterInfo, error) {
+	clusterInfoObj := clusterInfo{
+		secret:  &corev1.Secret{},
+		cluster: &cluster_registry.Cluster{},
+	}
+
+	if timeout == 0 {
+		err := c.client.Get(context.TODO(), objectKey, clusterInfoObj.secret)
+		if err != nil {
+			return clusterInfoObj, err
 		}
 
-		if !clusterInfoObj.secret.CreationTimestamp.IsZero() && !clusterInfoObj.cluster.CreationTimestamp.IsZero() {
-			break
-		} else if timeout == 0 {
-			err := errors.New("timeout reached")
+		err = c.client.Get(context.TODO(), objectKey, clusterInfoObj.cluster)
+		if err != nil {
 			return clusterInfoObj, err
 		}
-		time.Sleep(1 * time.Second)
-		timeout--
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		err := c.waitFor(ctx, objectKey, &corev1.Secret{}, &corev1.SecretList{}, func(object client.Object) bool {
+			clusterInfoObj.secret = object.(*corev1.Secret)
+			return true
+		})
+		if err != nil {
+			return clusterInfoObj, fmt.Errorf("secret %s: %w", objectKey, err)
+		}
+
+		err = c.waitFor(ctx, objectKey, &cluster_registry.Cluster{}, &cluster_registry.ClusterList{}, func(object client.Object) bool {
+			clusterInfoObj.cluster = object.(*cluster_registry.Cluster)
+			return true
+		})
+		if err != nil {
+			return clusterInfoObj, fmt.Errorf("cluster %s: %w", objectKey.Name, err)
+		}
 	}
 
 	clusterInfoObj.secret.ResourceVersion = ""
 	clusterInfoObj.cluster.ResourceVersion = ""
-	clusterInfoObj.restClient = clientset
 
 	return clusterInfoObj, nil
 }
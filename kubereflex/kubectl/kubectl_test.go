@@ -2,17 +2,30 @@ package kubectl
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	stdio "io"
 	"k8s.io/client-go/util/homedir"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"github.com/arpad-csepi/KLI/kubereflex/io"
 
@@ -27,6 +40,9 @@ var testDeploymentAnnotations = map[string]string{"deploymentTestReleaseName": t
 var objectKey1 = client.ObjectKey{Namespace: testNamespaceName, Name: "demo-active"}
 var objectKey2 = client.ObjectKey{Namespace: testNamespaceName, Name: "demo-passive"}
 
+var testIdentity1 = ClusterIdentity{Name: objectKey1.Name, Namespace: testNamespaceName}
+var testIdentity2 = ClusterIdentity{Name: objectKey2.Name, Namespace: testNamespaceName}
+
 var testSecret1 = &corev1.Secret{
 	ObjectMeta: metav1.ObjectMeta{
 		Name:      objectKey1.Name,
@@ -74,52 +90,45 @@ var testDeployment = appsv1.Deployment{
 	},
 }
 
+var testClients []*Clientset
+
 func createTestClient() {
-	if len(clients) == 0 {
-		var err error
+	if len(testClients) == 0 {
 		var kubeconfig string
 		if home := homedir.HomeDir(); home != "" {
 			kubeconfig = filepath.Join(home, ".kube", "config")
 		}
 
-		clientConfig1 := map[string]string{
-			"kubeconfig": kubeconfig,
-			"context":    "kind-kind-test",
-		}
-		clientConfig2 := map[string]string{
-			"kubeconfig": kubeconfig,
-			"context":    "kind-kind2-test",
-		}
-
-		err = CreateClient(clientConfig1, clientConfig2)
-		if err != nil {
-			panic(err)
+		for _, context := range []string{"kind-kind-test", "kind-kind2-test"} {
+			clientset, err := NewClientset(kubeconfig, context)
+			if err != nil {
+				panic(err)
+			}
+			testClients = append(testClients, clientset)
 		}
 	}
 }
 
 // BUG: clusterCRD will be overriden after first Apply. Cannot use for second Apply!
 // TODO: Should apply CRD for all clientset at once
-func appendCRD() {
+func appendCRD(clientset *Clientset) {
 	url := "https://raw.githubusercontent.com/cisco-open/cluster-registry-controller/cb563ec383a6a98f8d8e5c79d3350997b7e70075/deploy/charts/cluster-registry/crds/clusterregistry.k8s.cisco.com_clusters.yaml"
 	clusterCRD, err := io.GetClusterCRD(url)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	_ = Apply(clusterCRD) // Need clientset mapper refresh
+	_, _ = clientset.Apply(clusterCRD, false) // Need clientset mapper refresh
 
 	time.Sleep(3 * time.Second) // Wait for cluster CRD init
-	clients = []Clientset{}     // Delete all clientset with old mapping
-	createTestClient()          // Create new clientsets with new mapping (memcache will be invalidated)
 }
 
-func GetNamespaceStatus(namespace string) string {
+func GetNamespaceStatus(clientset *Clientset, namespace string) string {
 	key := client.ObjectKey{Name: namespace}
 	ns := &corev1.Namespace{
 		Status: corev1.NamespaceStatus{},
 	}
-	err := ActiveClientset.client.Get(context.TODO(), key, ns, &client.GetOptions{})
+	err := clientset.client.Get(context.TODO(), key, ns, &client.GetOptions{})
 	if err != nil && err.Error() == "namespaces \"namespace-for-testing\" not found" {
 		return "Not found"
 	}
@@ -131,7 +140,7 @@ func GetNamespaceStatus(namespace string) string {
 	return "Terminating"
 }
 
-func WaitForReadyDeployment(deployment appsv1.Deployment) {
+func WaitForReadyDeployment(clientset *Clientset, deployment appsv1.Deployment) {
 	key := client.ObjectKey{
 		Name:      deployment.Name,
 		Namespace: deployment.Namespace}
@@ -139,7 +148,7 @@ func WaitForReadyDeployment(deployment appsv1.Deployment) {
 
 	timeout := 30 * time.Second
 	for timeout > 0 {
-		err := ActiveClientset.client.Get(context.TODO(), key, deploy, &client.GetOptions{})
+		err := clientset.client.Get(context.TODO(), key, deploy, &client.GetOptions{})
 		if err == nil && deploy.Status.ReadyReplicas > 0 && deploy.Status.Replicas == deploy.Status.ReadyReplicas {
 			return
 		}
@@ -150,18 +159,22 @@ func WaitForReadyDeployment(deployment appsv1.Deployment) {
 }
 
 func setupCluster() {
-	for i := 0; i < len(clients); i++ {
-		SetActiveClientset(clients[i])
+	for _, clientset := range testClients {
+		appendCRD(clientset)
+	}
 
-		appendCRD()
+	// Create new clientsets with new mapping (memcache will be invalidated)
+	testClients = nil
+	createTestClient()
 
+	for _, clientset := range testClients {
 		timeout := 120 * time.Second
 		for true {
-			nsPhase := GetNamespaceStatus(testNamespaceName)
+			nsPhase := GetNamespaceStatus(clientset, testNamespaceName)
 			if nsPhase == "Active" {
 				break
 			} else if nsPhase == "Not found" {
-				_ = CreateNamespace(testNamespaceName)
+				_ = clientset.CreateNamespace(testNamespaceName)
 			}
 
 			fmt.Println("Phase: " + nsPhase)
@@ -176,17 +189,15 @@ func setupCluster() {
 }
 
 func resetCluster() {
-	for i := 0; i < len(clients); i++ {
-		SetActiveClientset(clients[i])
-
-		_ = Remove(&testDeployment)
+	_, _ = Detach(testClients, []ClusterIdentity{testIdentity1, testIdentity2})
 
-		_ = Detach(testNamespaceName, testNamespaceName)
+	for _, clientset := range testClients {
+		_ = clientset.Remove(&testDeployment, RemoveOptions{})
 
-		_ = Remove(testCluster1)
-		_ = Remove(testCluster2)
-		_ = Remove(testSecret1)
-		_ = Remove(testSecret2)
+		_ = clientset.Remove(testCluster1, RemoveOptions{})
+		_ = clientset.Remove(testCluster2, RemoveOptions{})
+		_ = clientset.Remove(testSecret1, RemoveOptions{})
+		_ = clientset.Remove(testSecret2, RemoveOptions{})
 
 		testDeployment.ResourceVersion = ""
 		testCluster1.ResourceVersion = ""
@@ -194,7 +205,7 @@ func resetCluster() {
 		testSecret1.ResourceVersion = ""
 		testSecret2.ResourceVersion = ""
 
-		_ = DeleteNamespace(testNamespaceName)
+		_ = clientset.DeleteNamespace(testNamespaceName)
 	}
 }
 
@@ -202,7 +213,7 @@ func TestCreateNamespace(t *testing.T) {
 	createTestClient()
 	resetCluster()
 
-	err := CreateNamespace(testNamespaceName)
+	err := testClients[0].CreateNamespace(testNamespaceName)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -213,7 +224,7 @@ func TestGetNamespace(t *testing.T) {
 	resetCluster()
 	setupCluster()
 
-	namespace, err := GetNamespace(testNamespaceName)
+	namespace, err := testClients[0].GetNamespace(testNamespaceName)
 	if err != nil || namespace == nil || namespace.Name != testNamespaceName {
 		t.Error(err.Error())
 	}
@@ -225,7 +236,7 @@ func TestDeleteNamespace(t *testing.T) {
 	resetCluster()
 	setupCluster()
 
-	err := DeleteNamespace(testNamespaceName)
+	err := testClients[0].DeleteNamespace(testNamespaceName)
 	if err != nil {
 		t.Error(err.Error())
 	}
@@ -236,7 +247,7 @@ func TestIsNamespaceExists(t *testing.T) {
 	resetCluster()
 	setupCluster()
 
-	exists, err := IsNamespaceExists(testNamespaceName)
+	exists, err := testClients[0].IsNamespaceExists(testNamespaceName)
 	if err != nil && exists != true {
 		t.Error(err.Error())
 	}
@@ -247,12 +258,259 @@ func TestApply(t *testing.T) {
 	resetCluster()
 	setupCluster()
 
-	err := Apply(&testDeployment)
+	result, err := testClients[0].Apply(&testDeployment, false)
 	if err != nil {
 		t.Error(err)
 	}
+	if result != ApplyCreated {
+		t.Errorf("First apply should create the object, got: %s", result)
+	}
+
+	WaitForReadyDeployment(testClients[0], testDeployment)
+
+	result, err = testClients[0].Apply(testDeployment.DeepCopy(), false)
+	if err != nil {
+		t.Error(err)
+	}
+	if result != ApplyUnchanged {
+		t.Errorf("Apply of the same object should not change it, got: %s", result)
+	}
+
+	changedDeployment := testDeployment.DeepCopy()
+	changedDeployment.Labels = map[string]string{"changed": "true"}
+	result, err = testClients[0].Apply(changedDeployment, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if result != ApplyConfigured {
+		t.Errorf("Apply of the changed object should configure it, got: %s", result)
+	}
+}
+
+func TestApplyConfiguration(t *testing.T) {
+	runtimeScheme, err := newScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset := &Clientset{client: fake.NewClientBuilder().WithScheme(runtimeScheme).Build(), out: stdio.Discard}
 
-	WaitForReadyDeployment(testDeployment)
+	secret := testSecret1.DeepCopy()
+	secret.UID = "uid-from-an-other-cluster"
+	secret.ResourceVersion = "42"
+	secret.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "other"}}
+	secret.Data = map[string][]byte{"kubeconfig": []byte("config")}
+
+	applyObject, err := clientset.applyConfiguration(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applyObject.GetKind() != "Secret" || applyObject.GetAPIVersion() != "v1" {
+		t.Errorf("Apply configuration should have the kind and version: %s", applyObject.GroupVersionKind())
+	}
+	if applyObject.GetUID() != "" || applyObject.GetResourceVersion() != "" || applyObject.GetManagedFields() != nil {
+		t.Errorf("Apply configuration should not contain the fields which are set by the server: %v", applyObject.Object["metadata"])
+	}
+	if applyObject.GetName() != secret.Name || applyObject.Object["data"] == nil {
+		t.Errorf("Apply configuration should keep the name and data: %v", applyObject.Object)
+	}
+}
+
+func newTestObject(apiVersion string, kind string, namespace string, name string) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{}
+	object.SetAPIVersion(apiVersion)
+	object.SetKind(kind)
+	object.SetNamespace(namespace)
+	object.SetName(name)
+
+	return object
+}
+
+func TestApplyOrder(t *testing.T) {
+	objects := []*unstructured.Unstructured{
+		newTestObject("servicemesh.cisco.com/v1alpha1", "IstioControlPlane", "istio-system", "icp"),
+		newTestObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "meshgateways.servicemesh.cisco.com"),
+		newTestObject("servicemesh.cisco.com/v1alpha1", "MeshGateway", "istio-system", "gateway"),
+		newTestObject("v1", "Namespace", "", "istio-system"),
+	}
+
+	names := []string{}
+	for _, object := range ApplyOrder(objects) {
+		names = append(names, object.GetName())
+	}
+	if fmt.Sprint(names) != "[istio-system meshgateways.servicemesh.cisco.com icp gateway]" {
+		t.Errorf("Wrong apply order: %v", names)
+	}
+
+	names = []string{}
+	for _, object := range RemoveOrder(objects) {
+		names = append(names, object.GetName())
+	}
+	if fmt.Sprint(names) != "[gateway icp meshgateways.servicemesh.cisco.com istio-system]" {
+		t.Errorf("Wrong remove order: %v", names)
+	}
+
+	if objects[0].GetName() != "icp" {
+		t.Error("Apply order should not change the order of the objects")
+	}
+}
+
+func newTestObjectClientset(t *testing.T, objects ...client.Object) *Clientset {
+	runtimeScheme, err := newScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+
+	fakeClient := fake.NewClientBuilder().WithScheme(runtimeScheme).WithRESTMapper(mapper).WithObjects(objects...).Build()
+	return &Clientset{client: fakeClient, out: stdio.Discard}
+}
+
+func TestResolveScope(t *testing.T) {
+	clientset := newTestObjectClientset(t)
+
+	configMap := newTestObject("v1", "ConfigMap", "", "mesh")
+	err := clientset.resolveScope(configMap)
+	if err != nil || configMap.GetNamespace() != metav1.NamespaceDefault {
+		t.Errorf("Namespaced object without namespace should get the default namespace: %q, %v", configMap.GetNamespace(), err)
+	}
+
+	namespace := newTestObject("v1", "Namespace", "istio-system", "istio-system")
+	err = clientset.resolveScope(namespace)
+	if err != nil || namespace.GetNamespace() != "" {
+		t.Errorf("Cluster scoped object should not have namespace: %q, %v", namespace.GetNamespace(), err)
+	}
+
+	gateway := newTestObject("servicemesh.cisco.com/v1alpha1", "MeshGateway", "istio-system", "gateway")
+	err = clientset.resolveScope(gateway)
+	if !meta.IsNoMatchError(err) {
+		t.Errorf("Unknown kind should return no match error, got: %v", err)
+	}
+}
+
+func TestRemoveObjects(t *testing.T) {
+	clientset := newTestObjectClientset(t,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespaceName, Name: "keep"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespaceName, Name: "remove"}})
+
+	err := clientset.RemoveObjects([]*unstructured.Unstructured{
+		newTestObject("v1", "ConfigMap", testNamespaceName, "remove"),
+		newTestObject("v1", "ConfigMap", testNamespaceName, "missing"),
+		newTestObject("servicemesh.cisco.com/v1alpha1", "MeshGateway", testNamespaceName, "gateway"),
+	}, RemoveOptions{PropagationPolicy: metav1.DeletePropagationForeground, Wait: true, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configMaps := &corev1.ConfigMapList{}
+	err = clientset.client.List(context.TODO(), configMaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configMaps.Items) != 1 || configMaps.Items[0].Name != "keep" {
+		t.Errorf("Only the named object should be removed: %v", configMaps.Items)
+	}
+}
+
+func TestRemoveStuckTerminating(t *testing.T) {
+	clientset := newTestObjectClientset(t,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespaceName, Name: "stuck", Finalizers: []string{"kli.test/finalizer"}}})
+
+	err := clientset.Remove(newTestObject("v1", "ConfigMap", testNamespaceName, "stuck"), RemoveOptions{Wait: true, Timeout: 500 * time.Millisecond})
+	if !errors.Is(err, ErrStuckTerminating) {
+		t.Fatalf("Object with finalizer should be stuck terminating, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "ConfigMap "+testNamespaceName+"/stuck (finalizers: kli.test/finalizer)") {
+		t.Errorf("Stuck object should be reported with its finalizers: %v", err)
+	}
+
+	err = clientset.Remove(newTestObject("v1", "ConfigMap", testNamespaceName, "stuck"), RemoveOptions{})
+	if err != nil {
+		t.Errorf("Remove without wait should not wait for the finalizers: %v", err)
+	}
+}
+
+func writeTestKubeconfig(t *testing.T) string {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	content := "apiVersion: v1\nkind: Config\nclusters:\n- name: test\n  cluster:\n    server: https://127.0.0.1:6443\n" +
+		"contexts:\n- name: test\n  context:\n    cluster: test\n    user: test\nusers:\n- name: test\n  user: {}\ncurrent-context: test\n"
+	err := os.WriteFile(kubeconfig, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kubeconfig
+}
+
+func TestNewClientsetContextNotFound(t *testing.T) {
+	kubeconfig := writeTestKubeconfig(t)
+
+	_, err := NewClientset(kubeconfig, "not-a-context")
+	if !errors.Is(err, ErrContextNotFound) {
+		t.Errorf("Unknown context should return ErrContextNotFound, got: %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"major": "1", "minor": "27", "gitVersion": "v1.27.1"}`)
+	}))
+	defer server.Close()
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	content := "apiVersion: v1\nkind: Config\nclusters:\n- name: test\n  cluster:\n    server: " + server.URL + "\n    insecure-skip-tls-verify: true\n" +
+		"contexts:\n- name: test\n  context:\n    cluster: test\n    user: test\nusers:\n- name: test\n  user: {}\ncurrent-context: test\n"
+	err := os.WriteFile(kubeconfig, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Ping(kubeconfig, "test", time.Second)
+	if err != nil {
+		t.Errorf("Running API server should be reachable: %v", err)
+	}
+
+	err = Ping(kubeconfig, "not-a-context", time.Second)
+	if !errors.Is(err, ErrContextNotFound) {
+		t.Errorf("Unknown context should return ErrContextNotFound, got: %v", err)
+	}
+
+	server.Close()
+	err = Ping(kubeconfig, "test", time.Second)
+	if err == nil {
+		t.Errorf("Stopped API server should not be reachable")
+	}
+}
+
+func TestNewClientsetConcurrent(t *testing.T) {
+	kubeconfig := writeTestKubeconfig(t)
+
+	var wg sync.WaitGroup
+	clientsets := make([]*Clientset, 8)
+	errs := make([]error, len(clientsets))
+	for i := range clientsets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clientsets[i], errs[i] = NewClientset(kubeconfig, "test")
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range clientsets {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+
+		endpoint, err := clientsets[i].GetAPIServerEndpoint()
+		if err != nil || endpoint != "127.0.0.1:6443" {
+			t.Errorf("Wrong API server endpoint: %s, %v", endpoint, err)
+		}
+	}
 }
 
 func TestRemove(t *testing.T) {
@@ -260,10 +518,10 @@ func TestRemove(t *testing.T) {
 	resetCluster()
 	setupCluster()
 
-	_ = Apply(&testDeployment)
-	WaitForReadyDeployment(testDeployment)
+	_, _ = testClients[0].Apply(&testDeployment, false)
+	WaitForReadyDeployment(testClients[0], testDeployment)
 
-	err := Remove(&testDeployment)
+	err := testClients[0].Remove(&testDeployment, RemoveOptions{})
 
 	if err != nil {
 		t.Error("Try to delete non-exist custom resource")
@@ -273,7 +531,7 @@ func TestRemove(t *testing.T) {
 func TestAPIServerEndpoint(t *testing.T) {
 	createTestClient()
 
-	endpoint, err := GetAPIServerEndpoint()
+	endpoint, err := testClients[0].GetAPIServerEndpoint()
 	if err != nil || endpoint == "" {
 		t.Error(err.Error())
 	}
@@ -284,31 +542,248 @@ func TestVerify(t *testing.T) {
 	resetCluster()
 	setupCluster()
 
-	_ = Apply(&testDeployment)
-	WaitForReadyDeployment(testDeployment)
+	_, _ = testClients[0].Apply(&testDeployment, false)
+	WaitForReadyDeployment(testClients[0], testDeployment)
 
 	testTimeout := 15 * time.Second
-	err := Verify(testDeploymentName, testNamespaceName, testTimeout)
+	err := testClients[0].Verify([]Workload{{Kind: "Deployment", Namespace: testNamespaceName, Name: testDeploymentName}}, testTimeout)
 	if err != nil {
 		t.Error(err.Error())
 	}
+
+	err = testClients[0].Verify([]Workload{{Kind: "Deployment", Namespace: testNamespaceName, Name: "missing-deployment"}}, time.Second)
+	if !errors.Is(err, ErrDeploymentNotReady) {
+		t.Errorf("Missing deployment should not be ready, got: %v", err)
+	}
 }
 
-func TestGetDeploymentName(t *testing.T) {
-	createTestClient()
-	resetCluster()
-	setupCluster()
+func TestWaitFor(t *testing.T) {
+	runtimeScheme, err := newScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset := &Clientset{client: fake.NewClientBuilder().WithScheme(runtimeScheme).Build(), out: stdio.Discard}
 
-	_ = Apply(&testDeployment)
-	WaitForReadyDeployment(testDeployment)
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: testDeploymentName, Namespace: testNamespaceName},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, ReadyReplicas: 1, AvailableReplicas: 1},
+	}
+	workload := Workload{Kind: "Deployment", Namespace: testNamespaceName, Name: testDeploymentName}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = clientset.client.Create(context.TODO(), deployment.DeepCopy())
+	}()
 
-	deploymentName, err := GetDeploymentName(testDeploymentReleaseName, testNamespaceName)
+	start := time.Now()
+	err = clientset.Verify([]Workload{workload}, 10*time.Second)
 	if err != nil {
-		t.Error(err.Error())
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("The created deployment should be detected by the watch, it took %s", time.Since(start))
+	}
+
+	missing := Workload{Kind: "Deployment", Namespace: testNamespaceName, Name: "missing-deployment"}
+	err = clientset.Verify([]Workload{missing}, 500*time.Millisecond)
+	if !errors.Is(err, ErrDeploymentNotReady) {
+		t.Errorf("Missing deployment should not be ready, got: %v", err)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	replicas := int32(2)
+	partition := int32(1)
+
+	tests := []struct {
+		name   string
+		object client.Object
+		ready  bool
+		failed bool
+	}{
+		{"deployment ready", &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Generation: 2},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, ReadyReplicas: 2, AvailableReplicas: 2},
+		}, true, false},
+		{"deployment generation not observed", &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Generation: 3},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, ReadyReplicas: 2, AvailableReplicas: 2},
+		}, false, false},
+		{"deployment rollout in progress", &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Generation: 2},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1, ReadyReplicas: 3, AvailableReplicas: 3},
+		}, false, false},
+		{"deployment old replicas terminating", &appsv1.Deployment{
+			Spec:   appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 2, ReadyReplicas: 3, AvailableReplicas: 3},
+		}, false, false},
+		{"deployment progress deadline exceeded", &appsv1.Deployment{
+			Spec: appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+			}},
+		}, false, true},
+		{"statefulset ready", &appsv1.StatefulSet{
+			Spec:   appsv1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType}},
+			Status: appsv1.StatefulSetStatus{UpdatedReplicas: 2, AvailableReplicas: 2, CurrentRevision: "r1", UpdateRevision: "r1"},
+		}, true, false},
+		{"statefulset revision rolling out", &appsv1.StatefulSet{
+			Spec:   appsv1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType}},
+			Status: appsv1.StatefulSetStatus{UpdatedReplicas: 2, AvailableReplicas: 2, CurrentRevision: "r1", UpdateRevision: "r2"},
+		}, false, false},
+		{"statefulset partitioned", &appsv1.StatefulSet{
+			Spec: appsv1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType, RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition}}},
+			Status: appsv1.StatefulSetStatus{UpdatedReplicas: 1, AvailableReplicas: 2, CurrentRevision: "r1", UpdateRevision: "r2"},
+		}, true, false},
+		{"daemonset not available", &appsv1.DaemonSet{
+			Spec:   appsv1.DaemonSetSpec{UpdateStrategy: appsv1.DaemonSetUpdateStrategy{Type: appsv1.RollingUpdateDaemonSetStrategyType}},
+			Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 2},
+		}, false, false},
+		{"daemonset ready", &appsv1.DaemonSet{
+			Spec:   appsv1.DaemonSetSpec{UpdateStrategy: appsv1.DaemonSetUpdateStrategy{Type: appsv1.RollingUpdateDaemonSetStrategyType}},
+			Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3},
+		}, true, false},
+		{"job running", &batchv1.Job{Status: batchv1.JobStatus{Active: 1}}, false, false},
+		{"job complete", &batchv1.Job{Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+		}}}, true, false},
+		{"job failed", &batchv1.Job{Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"},
+		}}}, false, true},
+		{"crd not established", &apiextensionsv1.CustomResourceDefinition{}, false, false},
+		{"crd established", &apiextensionsv1.CustomResourceDefinition{Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.NamesAccepted, Status: apiextensionsv1.ConditionTrue},
+				{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+			},
+		}}, true, false},
+	}
+
+	for _, test := range tests {
+		readiness := readinessOf(test.object)
+		if readiness.Ready != test.ready || readiness.Failed != test.failed {
+			t.Errorf("%s: got ready %t failed %t (%s)", test.name, readiness.Ready, readiness.Failed, readiness.Reason)
+		}
+	}
+}
+
+func TestFindReleaseWorkloads(t *testing.T) {
+	runtimeScheme, err := newScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helmAnnotations := func(release string, namespace string) map[string]string {
+		return map[string]string{releaseNameAnnotation: release, releaseNamespaceAnnotation: namespace}
+	}
+	objects := []client.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespaceName, Name: "operator", Annotations: helmAnnotations(testDeploymentReleaseName, testNamespaceName)}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespaceName, Name: "webhook", Annotations: helmAnnotations(testDeploymentReleaseName, testNamespaceName)}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespaceName, Name: "database", Labels: map[string]string{instanceLabel: testDeploymentReleaseName}}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespaceName, Name: "other-release", Annotations: helmAnnotations("other", testNamespaceName), Labels: map[string]string{instanceLabel: testDeploymentReleaseName}}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespaceName, Name: "other-namespace", Annotations: helmAnnotations(testDeploymentReleaseName, "default")}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: testNamespaceName, Name: "release-name-in-annotation", Annotations: testDeploymentAnnotations}},
+	}
+	clientset := &Clientset{client: fake.NewClientBuilder().WithScheme(runtimeScheme).WithObjects(objects...).Build(), out: stdio.Discard}
+
+	workloads, err := clientset.FindReleaseWorkloads(testDeploymentReleaseName, testNamespaceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Workload{
+		{Kind: "Deployment", Namespace: testNamespaceName, Name: "operator"},
+		{Kind: "Deployment", Namespace: testNamespaceName, Name: "webhook"},
+		{Kind: "StatefulSet", Namespace: testNamespaceName, Name: "database"},
+	}
+	if fmt.Sprint(workloads) != fmt.Sprint(expected) {
+		t.Errorf("Wrong release workloads: %v", workloads)
+	}
+}
+
+func TestLocalClusterOf(t *testing.T) {
+	newCluster := func(name string, clusterID string, clusterType string) unstructured.Unstructured {
+		cluster := unstructured.Unstructured{Object: map[string]interface{}{}}
+		cluster.SetName(name)
+		_ = unstructured.SetNestedField(cluster.Object, clusterID, "spec", "clusterID")
+		_ = unstructured.SetNestedField(cluster.Object, clusterType, "status", "type")
+		return cluster
+	}
+
+	tests := []struct {
+		name     string
+		clusters []unstructured.Unstructured
+		expected string
+	}{
+		{"cluster ID", []unstructured.Unstructured{newCluster("peer", "other-uid", "Peer"), newCluster("mesh2-active", "kube-system-uid", "")}, "mesh2-active"},
+		{"cluster ID before local type", []unstructured.Unstructured{newCluster("stale", "old-uid", "Local"), newCluster("local", "kube-system-uid", "Local")}, "local"},
+		{"local type", []unstructured.Unstructured{newCluster("peer", "other-uid", "Peer"), newCluster("local", "", "Local")}, "local"},
+		{"local type before configured name", []unstructured.Unstructured{newCluster("demo-active", "", ""), newCluster("local", "", "Local")}, "local"},
+		{"configured name", []unstructured.Unstructured{newCluster("peer", "other-uid", "Peer"), newCluster("demo-active", "", "")}, "demo-active"},
+		{"only peers", []unstructured.Unstructured{newCluster("peer", "other-uid", "Peer")}, ""},
+		{"no cluster", nil, ""},
+	}
+
+	for _, test := range tests {
+		name := localClusterOf(test.clusters, "kube-system-uid", "demo-active")
+		if name != test.expected {
+			t.Errorf("%s: expected local cluster %q, got %q", test.name, test.expected, name)
+		}
+	}
+}
+
+func TestLocalClusterName(t *testing.T) {
+	runtimeScheme, err := newScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeSystem := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceSystem, UID: "kube-system-uid"}}
+	clientset := &Clientset{client: fake.NewClientBuilder().WithScheme(runtimeScheme).WithObjects(kubeSystem).Build(), out: stdio.Discard}
+	identity := ClusterIdentity{Name: "demo-active"}
+
+	_, err = clientset.localClusterName(identity, 0)
+	if !errors.Is(err, ErrLocalClusterNotFound) {
+		t.Errorf("Missing local cluster should not be found with zero timeout, got: %v", err)
 	}
 
-	if testDeploymentName != deploymentName {
-		t.Error("Deployment name is wrong!")
+	newCluster := func(name string) *unstructured.Unstructured {
+		cluster := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		cluster.SetGroupVersionKind(clusterListKind.GroupVersion().WithKind("Cluster"))
+		cluster.SetName(name)
+		return cluster
+	}
+
+	// the peer is listed, then the local Cluster with the configured name is created by the controller later
+	err = clientset.client.Create(context.TODO(), newCluster("peer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = clientset.client.Create(context.TODO(), newCluster("demo-active"))
+	}()
+
+	start := time.Now()
+	name, err := clientset.localClusterName(identity, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "demo-active" {
+		t.Errorf("Expected demo-active local cluster, got %s", name)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("The created Cluster should be detected by the watch, it took %s", time.Since(start))
+	}
+
+	_, err = clientset.localClusterName(identity, 0)
+	if err != nil {
+		t.Errorf("The created local cluster should be found with zero timeout, got: %v", err)
 	}
 }
 
@@ -317,11 +792,10 @@ func TestGetClusterInfo(t *testing.T) {
 	resetCluster()
 	setupCluster()
 
-	_ = Apply(testSecret1)
-	_ = Apply(testCluster1)
+	_, _ = testClients[0].Apply(testSecret1, false)
+	_, _ = testClients[0].Apply(testCluster1, false)
 
-	NamespacedClient := client.NewNamespacedClient(clients[0].client, testNamespaceName)
-	clusterInfo, err := getClusterInfo(NamespacedClient, objectKey1)
+	clusterInfo, err := testClients[0].getClusterInfo(objectKey1, 10*time.Second)
 	if err != nil {
 		t.Error(err)
 	}
@@ -336,20 +810,61 @@ func TestAttach(t *testing.T) {
 	resetCluster()
 	setupCluster()
 
-	_ = CreateNamespace(testNamespaceName)
-	_ = Apply(testSecret1)
-	_ = Apply(testCluster1)
+	_ = testClients[0].CreateNamespace(testNamespaceName)
+	_, _ = testClients[0].Apply(testSecret1, false)
+	_, _ = testClients[0].Apply(testCluster1, false)
+
+	_ = testClients[1].CreateNamespace(testNamespaceName)
+	_, _ = testClients[1].Apply(testSecret2, false)
+	_, _ = testClients[1].Apply(testCluster2, false)
 
-	SetActiveClientset(clients[1])
+	results, err := Attach(testClients, []ClusterIdentity{testIdentity1, testIdentity2})
+	if err != nil {
+		t.Error(err.Error())
+	}
 
-	_ = CreateNamespace(testNamespaceName)
-	_ = Apply(testSecret2)
-	_ = Apply(testCluster2)
+	if len(results) != 1 || results[0].Err != nil {
+		t.Errorf("Attach between the two clusters failed: %v", results)
+	}
 
-	err := Attach(testNamespaceName, testNamespaceName)
+	results, err = Attach(testClients, []ClusterIdentity{testIdentity1, testIdentity2})
 	if err != nil {
 		t.Error(err.Error())
 	}
+
+	if len(results) != 1 || results[0].Err != nil || len(results[0].Applied) != 4 {
+		t.Fatalf("Second attach between the two clusters failed: %v", results)
+	}
+	for _, object := range results[0].Applied {
+		if object.Result != ApplyUnchanged {
+			t.Errorf("Second attach should not change %s on %s, got: %s", object.Object, object.Cluster, object.Result)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	results := []PairResult{
+		{Source: "a", Target: "b", Applied: []PeerObject{
+			{Cluster: "a", Object: "Secret ns/b", Result: ApplyCreated},
+			{Cluster: "a", Object: "Cluster b", Result: ApplyConfigured},
+			{Cluster: "b", Object: "Secret ns/a", Result: ApplyUnchanged},
+		}},
+		{Source: "a", Target: "c", Err: errors.New("failed")},
+		{Source: "b", Target: "c", Applied: []PeerObject{
+			{Cluster: "b", Object: "Secret ns/c", Result: ApplyCreated},
+			{Cluster: "c", Object: "Secret ns/b", Result: ApplyCreated},
+		}},
+	}
+
+	expected := []ClusterSummary{
+		{Cluster: "a", Created: 1, Updated: 1},
+		{Cluster: "b", Created: 1, Unchanged: 1},
+		{Cluster: "c", Created: 1},
+	}
+	summaries := Summarize(results)
+	if fmt.Sprint(summaries) != fmt.Sprint(expected) {
+		t.Errorf("Wrong summary: %v", summaries)
+	}
 }
 
 func TestDetach(t *testing.T) {
@@ -357,20 +872,67 @@ func TestDetach(t *testing.T) {
 	resetCluster()
 	setupCluster()
 
-	_ = CreateNamespace(testNamespaceName)
-	_ = Apply(testSecret1)
-	_ = Apply(testCluster1)
-
-	SetActiveClientset(clients[1])
+	_ = testClients[0].CreateNamespace(testNamespaceName)
+	_, _ = testClients[0].Apply(testSecret1, false)
+	_, _ = testClients[0].Apply(testCluster1, false)
 
-	_ = CreateNamespace(testNamespaceName)
-	_ = Apply(testSecret2)
-	_ = Apply(testCluster2)
+	_ = testClients[1].CreateNamespace(testNamespaceName)
+	_, _ = testClients[1].Apply(testSecret2, false)
+	_, _ = testClients[1].Apply(testCluster2, false)
 
-	_ = Attach(testNamespaceName, testNamespaceName)
+	_, _ = Attach(testClients, []ClusterIdentity{testIdentity1, testIdentity2})
 
-	err := Detach(testNamespaceName, testNamespaceName)
+	results, err := Detach(testClients, []ClusterIdentity{testIdentity1, testIdentity2})
 	if err != nil {
 		t.Error(err.Error())
 	}
+
+	if len(results) != 1 || results[0].Err != nil {
+		t.Errorf("Detach between the two clusters failed: %v", results)
+	}
+}
+
+// failingClient return the error for every get, e.g. the secrets can not be read with the credentials of the cluster
+type failingClient struct {
+	client.WithWatch
+	err error
+}
+
+func (c failingClient) Get(_ context.Context, _ client.ObjectKey, _ client.Object, _ ...client.GetOption) error {
+	return c.err
+}
+
+func TestRemovePeer(t *testing.T) {
+	runtimeScheme, err := newScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		err     error
+		ignored bool
+	}{
+		{name: "not found", ignored: true},
+		{name: "no CRD", err: &meta.NoKindMatchError{GroupKind: cluster_registry.GroupVersion.WithKind("Cluster").GroupKind()}, ignored: true},
+		{name: "forbidden", err: apierrors.NewForbidden(corev1.Resource("secrets"), objectKey2.Name, errors.New("no access"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fakeClient client.WithWatch = fake.NewClientBuilder().WithScheme(runtimeScheme).Build()
+			if test.err != nil {
+				fakeClient = failingClient{WithWatch: fakeClient, err: test.err}
+			}
+			clientset := &Clientset{client: fakeClient, out: stdio.Discard}
+
+			err := clientset.removePeer(testIdentity1, testIdentity2)
+			if test.ignored && err != nil {
+				t.Errorf("Expected nothing to remove, got %v", err)
+			}
+			if !test.ignored && !errors.Is(err, test.err) {
+				t.Errorf("Expected the %s error, got %v", test.name, err)
+			}
+		})
+	}
 }
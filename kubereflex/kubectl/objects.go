@@ -0,0 +1,262 @@
+package kubectl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// crdEstablishTimeout is the maximum wait for the applied custom resource definitions before their custom resources are applied
+var crdEstablishTimeout = 30 * time.Second
+
+// applyPriority return the place of the kind in the apply order, the namespaces and the custom resource definitions are needed by the other objects
+func applyPriority(object *unstructured.Unstructured) int {
+	switch object.GroupVersionKind().GroupKind().String() {
+	case "Namespace":
+		return 0
+	case "CustomResourceDefinition.apiextensions.k8s.io":
+		return 1
+	}
+
+	return 2
+}
+
+// ApplyOrder return the objects in dependency order: the namespaces first, then the custom resource definitions and then every other object
+// Inside every group the order of the objects is kept
+func ApplyOrder(objects []*unstructured.Unstructured) []*unstructured.Unstructured {
+	ordered := append([]*unstructured.Unstructured{}, objects...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return applyPriority(ordered[i]) < applyPriority(ordered[j])
+	})
+
+	return ordered
+}
+
+// RemoveOrder return the objects in the reverse of the apply order, so the namespaces and the custom resource definitions are removed last
+func RemoveOrder(objects []*unstructured.Unstructured) []*unstructured.Unstructured {
+	ordered := ApplyOrder(objects)
+	for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+		ordered[i], ordered[j] = ordered[j], ordered[i]
+	}
+
+	return ordered
+}
+
+// resolveScope find the resource of the object kind with the RESTMapper and set the namespace by the scope of the resource
+// A namespaced object without namespace get the default namespace, the namespace of a cluster scoped object is removed
+func (c *Clientset) resolveScope(object *unstructured.Unstructured) error {
+	mapper := c.client.RESTMapper()
+	gvk := object.GroupVersionKind()
+
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// the kind can be served since the API was discovered, e.g. its custom resource definition is applied just now
+		if resettable, ok := mapper.(meta.ResettableRESTMapper); ok {
+			resettable.Reset()
+			mapping, err = mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("%s: %s is not served by the cluster, is its custom resource definition installed: %w", objectDescription(object), gvk, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		object.SetNamespace("")
+	} else if object.GetNamespace() == "" {
+		object.SetNamespace(metav1.NamespaceDefault)
+	}
+
+	return nil
+}
+
+// ApplyObjects apply the objects with server-side apply in dependency order and return the applied objects with their results in the same order
+// The custom resource definitions have to be established before the next objects are applied, so the custom resources of the same file can be applied
+// When an object fails, the objects which are applied before it are returned with the error
+func (c *Clientset) ApplyObjects(objects []*unstructured.Unstructured, force bool) ([]*unstructured.Unstructured, []ApplyResult, error) {
+	ordered := ApplyOrder(objects)
+	applied := []*unstructured.Unstructured{}
+	results := []ApplyResult{}
+	crds := []Workload{}
+
+	for _, object := range ordered {
+		if applyPriority(object) != 1 && len(crds) > 0 {
+			err := c.waitForCRDs(crds)
+			if err != nil {
+				return applied, results, err
+			}
+			crds = nil
+		}
+
+		err := c.resolveScope(object)
+		if err != nil {
+			return applied, results, err
+		}
+
+		result, err := c.Apply(object, force)
+		if err != nil {
+			return applied, results, err
+		}
+		applied = append(applied, object)
+		results = append(results, result)
+
+		if applyPriority(object) == 1 {
+			crds = append(crds, Workload{Kind: object.GetKind(), Name: object.GetName()})
+		}
+	}
+
+	return applied, results, nil
+}
+
+// waitForCRDs wait until the custom resource definitions are established and reset the RESTMapper, so their kinds are known
+func (c *Clientset) waitForCRDs(crds []Workload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), crdEstablishTimeout)
+	defer cancel()
+
+	for _, crd := range crds {
+		readiness, err := c.waitForWorkload(ctx, crd)
+		if err != nil {
+			return err
+		}
+		if !readiness.Ready {
+			return fmt.Errorf("%s (%s): %w", crd, readiness.Reason, ErrDeploymentNotReady)
+		}
+	}
+
+	if resettable, ok := c.client.RESTMapper().(meta.ResettableRESTMapper); ok {
+		resettable.Reset()
+	}
+
+	return nil
+}
+
+// removeTimeout is the wait for the deleted objects when the timeout of the RemoveOptions is not set
+var removeTimeout = 60 * time.Second
+
+// RemoveOptions set how the objects are deleted
+type RemoveOptions struct {
+	// PropagationPolicy tell how the dependents are deleted (Background, Foreground or Orphan), the default of the kind is used when it is empty
+	PropagationPolicy metav1.DeletionPropagation
+	// Wait until the deleted objects are gone from the cluster, so their finalizers are processed
+	Wait bool
+	// Timeout of the wait, the objects which are still on the cluster after it are reported
+	Timeout time.Duration
+}
+
+// RemoveObjects delete the objects in the reverse of the apply order, the objects which are not on the cluster are skipped
+func (c *Clientset) RemoveObjects(objects []*unstructured.Unstructured, options RemoveOptions) error {
+	toRemove := []client.Object{}
+	for _, object := range RemoveOrder(objects) {
+		err := c.resolveScope(object)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		toRemove = append(toRemove, object)
+	}
+
+	return c.remove(toRemove, options)
+}
+
+// remove delete the objects one by one in the order of the list, with wait every object has to be gone before the next one is deleted
+// The objects which are not gone until the timeout are reported with their finalizers and ErrStuckTerminating is returned
+func (c *Clientset) remove(objects []client.Object, options RemoveOptions) error {
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = removeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	deleteOptions := []client.DeleteOption{}
+	if options.PropagationPolicy != "" {
+		deleteOptions = append(deleteOptions, client.PropagationPolicy(options.PropagationPolicy))
+	}
+
+	stuck := []string{}
+	for _, object := range objects {
+		deleted, err := c.deleteObject(object, deleteOptions)
+		if err != nil {
+			return err
+		}
+		if deleted == nil || !options.Wait {
+			continue
+		}
+
+		err = c.waitForDeletion(ctx, deleted)
+		if errors.Is(err, context.DeadlineExceeded) {
+			// the object can be gone since the last read
+			err = c.client.Get(context.TODO(), client.ObjectKeyFromObject(deleted), deleted)
+			if !apierrors.IsNotFound(err) {
+				stuck = append(stuck, terminatingDescription(deleted))
+				continue
+			}
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.out, "%s is gone\n", objectDescription(deleted))
+	}
+
+	if len(stuck) > 0 {
+		fmt.Fprintln(c.out, "Aww. One or more resource is stuck terminating! Please check the finalizers of them.")
+		return fmt.Errorf("%s after %s: %w", strings.Join(stuck, ", "), timeout, ErrStuckTerminating)
+	}
+
+	return nil
+}
+
+// deleteObject delete the object and return it as unstructured with its kind, so it can be watched, nil is returned when the object is not on the cluster
+func (c *Clientset) deleteObject(object client.Object, deleteOptions []client.DeleteOption) (*unstructured.Unstructured, error) {
+	gvk, err := apiutil.GVKForObject(object, c.client.Scheme())
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := &unstructured.Unstructured{}
+	deleted.SetGroupVersionKind(gvk)
+	deleted.SetNamespace(object.GetNamespace())
+	deleted.SetName(object.GetName())
+
+	fmt.Fprintf(c.out, "Remove %s\n", objectDescription(deleted))
+
+	err = c.client.Delete(context.TODO(), deleted, deleteOptions...)
+	if apierrors.IsNotFound(err) {
+		fmt.Fprintln(c.out, "Resource is already deleted")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(c.out, "Resource deleted!")
+	return deleted, nil
+}
+
+// terminatingDescription return the kind, name and finalizers of an object which is not gone after its deletion
+func terminatingDescription(object *unstructured.Unstructured) string {
+	finalizers := object.GetFinalizers()
+	if len(finalizers) == 0 {
+		return fmt.Sprintf("%s (still terminating)", objectDescription(object))
+	}
+
+	return fmt.Sprintf("%s (finalizers: %s)", objectDescription(object), strings.Join(finalizers, ", "))
+}
@@ -0,0 +1,337 @@
+package kubectl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Workload is an object of a helm release which has to become ready after the install
+type Workload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// String return the kind and the name of the workload like "Deployment namespace/name"
+func (w Workload) String() string {
+	if w.Namespace == "" {
+		return fmt.Sprintf("%s %s", w.Kind, w.Name)
+	}
+
+	return fmt.Sprintf("%s %s/%s", w.Kind, w.Namespace, w.Name)
+}
+
+// IsWorkloadKind check the readiness of the kind is verified, the objects of other kinds are ready when they exist
+func IsWorkloadKind(kind string) bool {
+	switch kind {
+	case "Deployment", "StatefulSet", "DaemonSet", "Job", "CustomResourceDefinition":
+		return true
+	}
+
+	return false
+}
+
+// releaseNameAnnotation, releaseNamespaceAnnotation and instanceLabel mark the objects of a helm release
+const (
+	releaseNameAnnotation      = "meta.helm.sh/release-name"
+	releaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+	instanceLabel              = "app.kubernetes.io/instance"
+)
+
+// FindReleaseWorkloads list the deployments, statefulsets, daemonsets and jobs of the namespace which belong to the helm release
+// An object belong to the release by the helm release annotations, or by the app.kubernetes.io/instance label when it has no helm annotations
+func (c *Clientset) FindReleaseWorkloads(releaseName string, namespace string) ([]Workload, error) {
+	workloads := []Workload{}
+
+	for _, kind := range []string{"Deployment", "StatefulSet", "DaemonSet", "Job"} {
+		_, list, err := newWorkloadObject(kind)
+		if err != nil {
+			return nil, err
+		}
+
+		err = c.client.List(context.TODO(), list, client.InNamespace(namespace))
+		if err != nil {
+			return nil, err
+		}
+
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			object, ok := item.(client.Object)
+			if !ok || !belongsToRelease(object, releaseName, namespace) {
+				continue
+			}
+
+			workloads = append(workloads, Workload{Kind: kind, Namespace: object.GetNamespace(), Name: object.GetName()})
+		}
+	}
+
+	return workloads, nil
+}
+
+// belongsToRelease check the helm release annotations of the object, the instance label is used only when the object has no helm annotations
+func belongsToRelease(object client.Object, releaseName string, namespace string) bool {
+	annotations := object.GetAnnotations()
+	if name, ok := annotations[releaseNameAnnotation]; ok {
+		releaseNamespace := annotations[releaseNamespaceAnnotation]
+		return name == releaseName && (releaseNamespace == "" || releaseNamespace == namespace)
+	}
+
+	return object.GetLabels()[instanceLabel] == releaseName
+}
+
+// Readiness is the state of a workload, Reason tell why it is not ready yet
+// Failed is true when the workload will never become ready, e.g. the job is failed
+type Readiness struct {
+	Ready  bool
+	Failed bool
+	Reason string
+}
+
+// WorkloadReadiness get the workload from the cluster and check its readiness once
+func (c *Clientset) WorkloadReadiness(workload Workload) (Readiness, error) {
+	object, _, err := newWorkloadObject(workload.Kind)
+	if err != nil {
+		return Readiness{}, err
+	}
+
+	err = c.client.Get(context.TODO(), workload.objectKey(), object, &client.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return Readiness{Reason: "not found"}, nil
+	}
+	if err != nil {
+		return Readiness{}, err
+	}
+
+	return readinessOf(object), nil
+}
+
+// waitForWorkload watch the workload until it is ready, failed or the context is done and return its last readiness
+func (c *Clientset) waitForWorkload(ctx context.Context, workload Workload) (Readiness, error) {
+	object, list, err := newWorkloadObject(workload.Kind)
+	if err != nil {
+		return Readiness{}, err
+	}
+
+	last := Readiness{Reason: "not found"}
+	err = c.waitFor(ctx, workload.objectKey(), object, list, func(object client.Object) bool {
+		last = readinessOf(object)
+		return last.Ready || last.Failed
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return last, nil
+	}
+
+	return last, err
+}
+
+// newWorkloadObject return an empty object and list of the workload kind
+func newWorkloadObject(kind string) (client.Object, client.ObjectList, error) {
+	switch kind {
+	case "Deployment":
+		return &appsv1.Deployment{}, &appsv1.DeploymentList{}, nil
+	case "StatefulSet":
+		return &appsv1.StatefulSet{}, &appsv1.StatefulSetList{}, nil
+	case "DaemonSet":
+		return &appsv1.DaemonSet{}, &appsv1.DaemonSetList{}, nil
+	case "Job":
+		return &batchv1.Job{}, &batchv1.JobList{}, nil
+	case "CustomResourceDefinition":
+		return &apiextensionsv1.CustomResourceDefinition{}, &apiextensionsv1.CustomResourceDefinitionList{}, nil
+	}
+
+	return nil, nil, fmt.Errorf("readiness of %s kind is not supported", kind)
+}
+
+func (w Workload) objectKey() client.ObjectKey {
+	return client.ObjectKey{Namespace: w.Namespace, Name: w.Name}
+}
+
+// readinessOf check the readiness of a workload object
+func readinessOf(object client.Object) Readiness {
+	switch workload := object.(type) {
+	case *appsv1.Deployment:
+		return deploymentReadiness(workload)
+	case *appsv1.StatefulSet:
+		return statefulSetReadiness(workload)
+	case *appsv1.DaemonSet:
+		return daemonSetReadiness(workload)
+	case *batchv1.Job:
+		return jobReadiness(workload)
+	case *apiextensionsv1.CustomResourceDefinition:
+		return crdReadiness(workload)
+	}
+
+	return Readiness{Ready: true}
+}
+
+// deploymentReadiness is ready when the controller observed the last change and every replica is updated and available, so a rollout in progress is not ready
+func deploymentReadiness(deployment *appsv1.Deployment) Readiness {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return Readiness{Reason: "rollout is not observed yet"}
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return Readiness{Failed: true, Reason: "progress deadline exceeded"}
+		}
+	}
+
+	replicas := desiredReplicas(deployment.Spec.Replicas)
+	if deployment.Status.UpdatedReplicas < replicas {
+		return Readiness{Reason: fmt.Sprintf("%d of %d replicas updated", deployment.Status.UpdatedReplicas, replicas)}
+	}
+	if deployment.Status.Replicas > deployment.Status.UpdatedReplicas {
+		return Readiness{Reason: fmt.Sprintf("%d old replicas are pending termination", deployment.Status.Replicas-deployment.Status.UpdatedReplicas)}
+	}
+	if deployment.Status.AvailableReplicas < replicas {
+		return Readiness{Reason: fmt.Sprintf("%d of %d replicas available", deployment.Status.AvailableReplicas, replicas)}
+	}
+
+	return Readiness{Ready: true}
+}
+
+// statefulSetReadiness is ready when the controller observed the last change, the replicas above the partition are updated and every replica is available
+func statefulSetReadiness(statefulSet *appsv1.StatefulSet) Readiness {
+	if statefulSet.Generation > statefulSet.Status.ObservedGeneration {
+		return Readiness{Reason: "rollout is not observed yet"}
+	}
+
+	replicas := desiredReplicas(statefulSet.Spec.Replicas)
+
+	if statefulSet.Spec.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType {
+		partition := int32(0)
+		if statefulSet.Spec.UpdateStrategy.RollingUpdate != nil && statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+			partition = *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition
+		}
+
+		if statefulSet.Status.UpdatedReplicas < replicas-partition {
+			return Readiness{Reason: fmt.Sprintf("%d of %d replicas updated", statefulSet.Status.UpdatedReplicas, replicas-partition)}
+		}
+		if partition == 0 && statefulSet.Status.UpdateRevision != statefulSet.Status.CurrentRevision {
+			return Readiness{Reason: fmt.Sprintf("revision %s is rolling out", statefulSet.Status.UpdateRevision)}
+		}
+	}
+
+	if statefulSet.Status.AvailableReplicas < replicas {
+		return Readiness{Reason: fmt.Sprintf("%d of %d replicas available", statefulSet.Status.AvailableReplicas, replicas)}
+	}
+
+	return Readiness{Ready: true}
+}
+
+// daemonSetReadiness is ready when the controller observed the last change and the pods are updated and available on every scheduled node
+func daemonSetReadiness(daemonSet *appsv1.DaemonSet) Readiness {
+	if daemonSet.Generation > daemonSet.Status.ObservedGeneration {
+		return Readiness{Reason: "rollout is not observed yet"}
+	}
+
+	desired := daemonSet.Status.DesiredNumberScheduled
+	if daemonSet.Spec.UpdateStrategy.Type == appsv1.RollingUpdateDaemonSetStrategyType && daemonSet.Status.UpdatedNumberScheduled < desired {
+		return Readiness{Reason: fmt.Sprintf("%d of %d pods updated", daemonSet.Status.UpdatedNumberScheduled, desired)}
+	}
+	if daemonSet.Status.NumberAvailable < desired {
+		return Readiness{Reason: fmt.Sprintf("%d of %d pods available", daemonSet.Status.NumberAvailable, desired)}
+	}
+
+	return Readiness{Ready: true}
+}
+
+// jobReadiness is ready when the job is completed and failed when the job is failed
+func jobReadiness(job *batchv1.Job) Readiness {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return Readiness{Ready: true}
+		case batchv1.JobFailed:
+			return Readiness{Failed: true, Reason: fmt.Sprintf("job failed: %s", condition.Message)}
+		}
+	}
+
+	return Readiness{Reason: fmt.Sprintf("%d pods succeeded, %d active", job.Status.Succeeded, job.Status.Active)}
+}
+
+// crdReadiness is ready when the custom resource definition is established, so its custom resources can be created
+func crdReadiness(crd *apiextensionsv1.CustomResourceDefinition) Readiness {
+	for _, condition := range crd.Status.Conditions {
+		if condition.Type == apiextensionsv1.NamesAccepted && condition.Status == apiextensionsv1.ConditionFalse {
+			return Readiness{Failed: true, Reason: fmt.Sprintf("names are not accepted: %s", condition.Message)}
+		}
+		if condition.Type == apiextensionsv1.Established && condition.Status == apiextensionsv1.ConditionTrue {
+			return Readiness{Ready: true}
+		}
+	}
+
+	return Readiness{Reason: "not established"}
+}
+
+// desiredReplicas return the replicas of the spec, it is 1 when it is not set
+func desiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+
+	return *replicas
+}
+
+// Verify watch every workload until all of them are ready or the timeout is reached, the readiness is detected as soon as the cluster report it
+// ErrDeploymentNotReady is returned with the not ready workloads and their reasons on timeout or when a workload is failed
+func (c *Clientset) Verify(workloads []Workload, timeout time.Duration) error {
+	if len(workloads) == 0 {
+		fmt.Fprintln(c.out, "No workload to verify")
+		return nil
+	}
+
+	fmt.Fprintf(c.out, "Verifing %d workloads...\n", len(workloads))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for i, workload := range workloads {
+		readiness, err := c.waitForWorkload(ctx, workload)
+		if err != nil {
+			return err
+		}
+
+		if readiness.Failed {
+			fmt.Fprintln(c.out, "Aww. One or more resource is failed! Please check your cluster to more info.")
+			return fmt.Errorf("%s (%s): %w", workload, readiness.Reason, ErrDeploymentNotReady)
+		}
+
+		if !readiness.Ready {
+			notReady := []string{fmt.Sprintf("%s (%s)", workload, readiness.Reason)}
+			for _, rest := range workloads[i+1:] {
+				readiness, err := c.WorkloadReadiness(rest)
+				if err == nil && !readiness.Ready {
+					notReady = append(notReady, fmt.Sprintf("%s (%s)", rest, readiness.Reason))
+				}
+			}
+
+			fmt.Fprintln(c.out, "Aww. One or more resource is not ready! Please check your cluster to more info.")
+			return fmt.Errorf("%s after %s: %w", strings.Join(notReady, ", "), timeout, ErrDeploymentNotReady)
+		}
+
+		fmt.Fprintf(c.out, "%s is ready\n", workload)
+	}
+
+	fmt.Fprintln(c.out, "Ok! Verify process was successful!")
+	return nil
+}
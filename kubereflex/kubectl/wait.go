@@ -0,0 +1,143 @@
+package kubectl
+
+import (
+	"context"
+	"math"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// watchBackoff is the wait before the object is read and watched again when the watch can not be started or it is closed
+var watchBackoff = wait.Backoff{
+	Duration: 250 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      10 * time.Second,
+}
+
+// waitFor wait until the condition is true for the object with the key, the list is the list type of the object
+// The object is read and then watched from its resource version, so a change is detected immediately without polling
+// When the watch can not be started (e.g. no watch permission) or it is closed, the object is read again after a growing backoff
+// The context error is returned when the context is done before the condition is true
+func (c *Clientset) waitFor(ctx context.Context, key client.ObjectKey, object client.Object, list client.ObjectList, condition func(client.Object) bool) error {
+	backoff := watchBackoff
+
+	for {
+		resourceVersion := ""
+
+		err := c.client.Get(ctx, key, object)
+		switch {
+		case err == nil:
+			if condition(object) {
+				return nil
+			}
+			resourceVersion = object.GetResourceVersion()
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case !apierrors.IsNotFound(err):
+			return err
+		}
+
+		done, err := c.watchUntil(ctx, key, list, resourceVersion, func(eventType watch.EventType, object client.Object) bool {
+			return eventType != watch.Deleted && condition(object)
+		})
+		if done || err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+// waitForDeletion wait until the object is deleted from the cluster, e.g. its finalizers are processed
+// The object is updated to its last state, so its finalizers can be reported when the context is done before the object is gone
+func (c *Clientset) waitForDeletion(ctx context.Context, object *unstructured.Unstructured) error {
+	key := client.ObjectKeyFromObject(object)
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(object.GroupVersionKind().GroupVersion().WithKind(object.GetKind() + "List"))
+
+	backoff := watchBackoff
+
+	for {
+		err := c.client.Get(ctx, key, object)
+		switch {
+		case apierrors.IsNotFound(err):
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			return err
+		}
+
+		done, err := c.watchUntil(ctx, key, list, object.GetResourceVersion(), func(eventType watch.EventType, changed client.Object) bool {
+			if eventType == watch.Deleted {
+				return true
+			}
+			if changed, ok := changed.(*unstructured.Unstructured); ok {
+				object.Object = changed.Object
+			}
+			return false
+		})
+		if done || err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+// watchUntil watch the object from the resource version until the handle of an added, modified or deleted event return true
+// With an empty key name every object of the list type in the key namespace is watched
+// false is returned without error when the watch can not be started, it is closed or it report an error, so the caller can start it again
+func (c *Clientset) watchUntil(ctx context.Context, key client.ObjectKey, list client.ObjectList, resourceVersion string, handle func(watch.EventType, client.Object) bool) (bool, error) {
+	options := &client.ListOptions{
+		Namespace: key.Namespace,
+		Raw:       &metav1.ListOptions{ResourceVersion: resourceVersion},
+	}
+	if key.Name != "" {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", key.Name)
+	}
+
+	watcher, err := c.client.Watch(ctx, list, options)
+	if err != nil {
+		return false, ctx.Err()
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return false, nil
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified, watch.Deleted:
+				object, ok := event.Object.(client.Object)
+				if ok && (key.Name == "" || object.GetName() == key.Name) && handle(event.Type, object) {
+					return true, nil
+				}
+			case watch.Error:
+				return false, nil
+			}
+		}
+	}
+}
@@ -1,238 +1,602 @@
 package kubereflex
 
 import (
+	"errors"
+	"fmt"
+	stdio "io"
+	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/arpad-csepi/KLI/kubereflex/helm"
 	"github.com/arpad-csepi/KLI/kubereflex/io"
 	"github.com/arpad-csepi/KLI/kubereflex/kubectl"
 
-	"github.com/manifoldco/promptui"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 var usedContexts = []string{}
 
-func ChooseContextFromConfig(kubeconfig *string) string {
-	contexts, err := io.GetContextsFromConfig(*kubeconfig)
+// out is the writer of the progress messages
+var out stdio.Writer = os.Stdout
+
+// clusterKey identify a cluster by the kubeconfig variable and the context, not by the kubeconfig path
+// Two clusters can use the same kubeconfig file and context, but every cluster has its own kubeconfig variable
+type clusterKey struct {
+	kubeconfig *string
+	context    string
+}
+
+// clusterOutputs are the writers of the progress messages per cluster
+var clusterOutputs = map[clusterKey]stdio.Writer{}
+var clusterOutputsLock sync.Mutex
+
+// SetOutput set the writer of the progress messages of kubereflex, helm and kubectl, the default is the standard output
+func SetOutput(w stdio.Writer) {
+	out = w
+	kubectl.SetOutput(w)
+}
+
+// SetClusterOutput set the writer of the progress messages about the cluster of the kubeconfig and context
+// Operations on different clusters can run at the same time and write their messages separately, nil restore the default writer
+// The cluster is identified by the kubeconfig pointer, so the operations have to get the same pointer, e.g. the kubeconfig field of the topology cluster
+func SetClusterOutput(kubeconfig *string, context string, w stdio.Writer) {
+	clusterOutputsLock.Lock()
+	defer clusterOutputsLock.Unlock()
+
+	if w == nil {
+		delete(clusterOutputs, clusterKey{kubeconfig: kubeconfig, context: context})
+		return
+	}
+
+	clusterOutputs[clusterKey{kubeconfig: kubeconfig, context: context}] = w
+}
+
+// clusterOutput return the writer of the progress messages about the cluster
+func clusterOutput(kubeconfig *string, context string) stdio.Writer {
+	clusterOutputsLock.Lock()
+	defer clusterOutputsLock.Unlock()
+
+	if w, ok := clusterOutputs[clusterKey{kubeconfig: kubeconfig, context: context}]; ok {
+		return w
+	}
+
+	return out
+}
+
+// newClientset return the kubectl handle of the cluster which write its messages to the output of the cluster
+func newClientset(kubeconfig *string, context string) (*kubectl.Clientset, error) {
+	clientset, err := kubectl.NewClientset(*kubeconfig, context)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	clientset.SetOutput(clusterOutput(kubeconfig, context))
 
-	notUsedContexts := []string{}
+	return clientset, nil
+}
 
+// pingTimeout is the maximum wait for the API server of a context in the context picker
+var pingTimeout = 2 * time.Second
+
+// ChooseContextFromConfig return the only unused context of the kubeconfig or ask the user to select one with the prompter (see SetPrompter)
+// The picker show every context with its server, user and reachability, the current-context is the first item
+// The contexts which are used for an other cluster are marked and they can be selected again only when the user confirm it, e.g. for a single-cluster setup
+// The kubeconfig can be a path list like KUBECONFIG
+func ChooseContextFromConfig(kubeconfig *string) (string, error) {
+	contexts, err := io.GetContexts(*kubeconfig)
+	if err != nil {
+		return "", err
+	}
+	if len(contexts) == 0 {
+		return "", fmt.Errorf("no context in %s: %w", *kubeconfig, ErrContextNotFound)
+	}
+
+	choices := []ContextChoice{}
+	notUsedContexts := []string{}
 	for _, context := range contexts {
-		usedFound := false
+		choice := ContextChoice{Context: context, Used: isUsedContext(context.Name)}
+		if !choice.Used {
+			notUsedContexts = append(notUsedContexts, context.Name)
+		}
+		choices = append(choices, choice)
+	}
+
+	if len(notUsedContexts) == 1 {
+		usedContexts = append(usedContexts, notUsedContexts[0])
+		return notUsedContexts[0], nil
+	}
+
+	// the reachability is only shown by the picker, the non-interactive prompter fail without waiting for the API servers
+	if _, nonInteractive := prompter.(NonInteractivePrompter); !nonInteractive {
+		pingContexts(*kubeconfig, choices)
+	}
 
-		for _, usedContext := range usedContexts {
-			if context == usedContext {
-				usedFound = true
+	label := fmt.Sprintf("Select context for the cluster from %s", *kubeconfig)
+	for {
+		selectedItem, err := prompter.SelectContext(label, choices)
+		if err != nil {
+			return "", err
+		}
+
+		if isUsedContext(selectedItem) {
+			confirmed, err := prompter.Confirm(fmt.Sprintf("%s is already used for an other cluster, use it again (single-cluster setup)", selectedItem))
+			if err != nil {
+				return "", err
+			}
+			if !confirmed {
+				continue
 			}
 		}
 
-		if !usedFound {
-			notUsedContexts = append(notUsedContexts, context)
+		usedContexts = append(usedContexts, selectedItem)
+		return selectedItem, nil
+	}
+}
+
+// isUsedContext tell the context is already selected for an other cluster
+func isUsedContext(context string) bool {
+	for _, usedContext := range usedContexts {
+		if context == usedContext {
+			return true
 		}
 	}
 
-	selectedItem := ""
+	return false
+}
+
+// pingContexts set the reachability of the contexts, the API servers are checked at the same time
+func pingContexts(kubeconfig string, choices []ContextChoice) {
+	var wg sync.WaitGroup
+	for i := range choices {
+		wg.Add(1)
+		go func(choice *ContextChoice) {
+			defer wg.Done()
+			choice.Reachable = kubectl.Ping(kubeconfig, choice.Name, pingTimeout) == nil
+		}(&choices[i])
+	}
+	wg.Wait()
+}
+
+// InstallHelmChart add the helm repository if it is needed and install the chart, ErrReleaseExists is returned when the release is already installed
+// The values of the valueLevels are merged in order, a later level override the same values of an earlier level
+func InstallHelmChart(chartUrl string, repositoryName string, chartName string, releaseName string, namespace string, args map[string]string, valueLevels []values.Options, kubeconfig *string, context string) (*release.Release, error) {
+	w := clusterOutput(kubeconfig, context)
 
-	if len(notUsedContexts) == 0 {
-		panic("no more unused context remained")
+	err := ensureRepository(repositoryName, chartUrl, w)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(notUsedContexts) > 1 {
-		prompt := promptui.Select{
-			Label: "Select context for the cluster",
-			Items: notUsedContexts,
-		}
-		_, selectedItem, err = prompt.Run()
+	return helm.Install(repositoryName, chartName, releaseName, namespace, args, valueLevels, kubeconfig, context, w)
+}
+
+// UpgradeHelmChart upgrade an existing release to the chart version in args["version"] with new values
+func UpgradeHelmChart(chartUrl string, repositoryName string, chartName string, releaseName string, namespace string, args map[string]string, valueLevels []values.Options, kubeconfig *string, context string) (*release.Release, error) {
+	w := clusterOutput(kubeconfig, context)
+
+	err := ensureRepository(repositoryName, chartUrl, w)
+	if err != nil {
+		return nil, err
+	}
+
+	return helm.Upgrade(repositoryName, chartName, releaseName, namespace, args, valueLevels, kubeconfig, context, w)
+}
+
+// PrepareHelmRepositories add the helm repositories which are not added yet and update every repository once, the repositories are given by name with their URL
+// The installs and upgrades which run after it can skip the repository update with args["skip-update"] = "true", so they only read the repository index
+func PrepareHelmRepositories(repositories map[string]string) error {
+	names := []string{}
+	for name := range repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := ensureRepository(name, repositories[name], out)
 		if err != nil {
-			panic(err)
+			return err
 		}
-	} else {
-		selectedItem = notUsedContexts[0]
 	}
 
-	usedContexts = append(usedContexts, selectedItem)
-
-	return selectedItem
+	return helm.RepositoryUpdate(out)
 }
 
-func InstallHelmChart(chartUrl string, repositoryName string, chartName string, releaseName string, namespace string, args map[string]string, kubeconfig *string, context string) {
-	isRepositoryExists, err := helm.IsRepositoryExists(repositoryName)
+// ensureRepository add the helm repository when it is not added yet
+func ensureRepository(repositoryName string, chartUrl string, w stdio.Writer) error {
+	isRepositoryExists, err := helm.IsRepositoryExists(repositoryName, w)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	if !isRepositoryExists {
-		err := helm.RepositoryAdd(repositoryName, chartUrl)
-		if err != nil {
-			panic(err)
-		}
+		return helm.RepositoryAdd(repositoryName, chartUrl, w)
 	}
 
-	err = helm.Install(repositoryName, chartName, releaseName, namespace, args, kubeconfig, context)
+	return nil
+}
+
+// RenderHelmChart resolve the chart version and render the release manifest without installing anything on the cluster
+// The chart is found directly by the chartUrl, the helm repositories are not added or updated
+func RenderHelmChart(chartUrl string, repositoryName string, chartName string, releaseName string, namespace string, args map[string]string, valueLevels []values.Options, kubeconfig *string, context string) (*release.Release, []string, error) {
+	helmRelease, err := helm.Render(chartUrl, repositoryName, chartName, releaseName, namespace, args, valueLevels, kubeconfig, context, clusterOutput(kubeconfig, context))
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
+
+	resources, err := helm.ManifestResources(helmRelease.Manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return helmRelease, resources, nil
 }
 
-func UninstallHelmChart(releaseName string, namespace string, kubeconfig *string, context string) {
-	err := helm.Uninstall(releaseName, namespace, kubeconfig, context)
+// GetHelmRelease return the installed release or nil when the release is not installed
+func GetHelmRelease(releaseName string, namespace string, kubeconfig *string, context string) (*release.Release, error) {
+	helmRelease, err := helm.Status(releaseName, namespace, kubeconfig, context)
+	if errors.Is(err, helm.ErrReleaseNotFound) {
+		return nil, nil
+	}
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+
+	return helmRelease, nil
+}
+
+// UninstallHelmChart uninstall the release from the cluster, a release which is not installed is skipped, but the other failures (e.g. RBAC or network errors) are returned
+func UninstallHelmChart(releaseName string, namespace string, kubeconfig *string, context string) error {
+	return helm.Uninstall(releaseName, namespace, kubeconfig, context, clusterOutput(kubeconfig, context))
 }
 
-func GetDeploymentName(releaseName string, namespace string, kubeconfig *string, context string) string {
-	clientConfig := map[string]string{
-		"kubeconfig": *kubeconfig,
-		"context":    context,
+// GetReleaseWorkloads return every workload of the release: the workloads of the release manifest and of the chart CRDs
+// and the workloads of the release namespace which have the helm release annotations or the app.kubernetes.io/instance label
+func GetReleaseWorkloads(releaseName string, namespace string, kubeconfig *string, context string) ([]kubectl.Workload, error) {
+	helmRelease, err := helm.Get(releaseName, namespace, kubeconfig, context)
+	if err != nil {
+		return nil, err
 	}
 
-	err := kubectl.CreateClient(clientConfig)
+	clientset, err := newClientset(kubeconfig, context)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	deploymentName, err := kubectl.GetDeploymentName(releaseName, namespace)
+	return releaseWorkloads(clientset, helmRelease)
+}
+
+// releaseWorkloads merge the workloads of the release manifest with the workloads which are found by the helm metadata on the cluster
+func releaseWorkloads(clientset *kubectl.Clientset, helmRelease *release.Release) ([]kubectl.Workload, error) {
+	workloads, err := ReleaseWorkloads(helmRelease)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
-	kubectl.RemoveAllClients()
+	found, err := clientset.FindReleaseWorkloads(helmRelease.Name, helmRelease.Namespace)
+	if err != nil {
+		return nil, err
+	}
 
-	return deploymentName
+	known := map[kubectl.Workload]bool{}
+	for _, workload := range workloads {
+		known[workload] = true
+	}
+	for _, workload := range found {
+		if !known[workload] {
+			workloads = append(workloads, workload)
+		}
+	}
+
+	return workloads, nil
 }
 
-func Verify(deploymentName string, namespace string, kubeconfig *string, context string, timeout time.Duration) {
-	clientConfig := map[string]string{
-		"kubeconfig": *kubeconfig,
-		"context":    context,
+// Verify wait until every workload of the release is ready: deployments, statefulsets, daemonsets, jobs and custom resource definitions
+// ErrDeploymentNotReady is returned when the timeout is reached or a workload is failed
+func Verify(releaseName string, namespace string, kubeconfig *string, context string, timeout time.Duration) error {
+	helmRelease, err := helm.Get(releaseName, namespace, kubeconfig, context)
+	if err != nil {
+		return err
 	}
 
-	err := kubectl.CreateClient(clientConfig)
+	clientset, err := newClientset(kubeconfig, context)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	err = kubectl.Verify(deploymentName, namespace, timeout)
+	workloads, err := releaseWorkloads(clientset, helmRelease)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	kubectl.RemoveAllClients()
+	return clientset.Verify(workloads, timeout)
 }
 
-func GetAPIServerEndpoint(kubeconfig *string, context string) string {
-	clientConfig := map[string]string{
-		"kubeconfig": *kubeconfig,
-		"context":    context,
+// ReleaseWorkloads return the objects of the release which readiness is verified, the custom resource definitions of the chart are the first
+func ReleaseWorkloads(helmRelease *release.Release) ([]kubectl.Workload, error) {
+	crds, err := helm.CRDObjects(helmRelease)
+	if err != nil {
+		return nil, err
 	}
 
-	err := kubectl.CreateClient(clientConfig)
+	objects, err := helm.ManifestObjects(helmRelease.Manifest)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	endpoint, err := kubectl.GetAPIServerEndpoint()
-	if err != nil {
-		panic(err)
+	workloads := []kubectl.Workload{}
+	for _, object := range append(crds, objects...) {
+		if !kubectl.IsWorkloadKind(object.Kind) {
+			continue
+		}
+
+		workload := kubectl.Workload{Kind: object.Kind, Namespace: object.Namespace, Name: object.Name}
+		if workload.Kind == "CustomResourceDefinition" {
+			workload.Namespace = ""
+		} else if workload.Namespace == "" {
+			workload.Namespace = helmRelease.Namespace
+		}
+
+		workloads = append(workloads, workload)
 	}
 
-	kubectl.RemoveAllClients()
+	return workloads, nil
+}
+
+// ReleaseRef is a helm release which is part of the status report
+type ReleaseRef struct {
+	Name      string
+	Namespace string
+}
+
+// WorkloadStatus is the readiness of a workload of a helm release
+type WorkloadStatus struct {
+	kubectl.Workload
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ReleaseStatus is the state of a helm release and its workloads
+type ReleaseStatus struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Chart     string `json:"chart,omitempty"`
+	Version   string `json:"version,omitempty"`
+	Status    string `json:"status"`
+	Revision  int    `json:"revision,omitempty"`
+	// Description is the last operation of the release, it record the resolved and the requested chart version
+	Description string           `json:"description,omitempty"`
+	Workloads   []WorkloadStatus `json:"workloads,omitempty"`
+	// Ready is true when every workload of the release is ready
+	Ready bool `json:"ready"`
+}
 
-	return endpoint
+// ClusterStatus is the state of KLI managed resources on a cluster
+type ClusterStatus struct {
+	Context       string                 `json:"context"`
+	Releases      []ReleaseStatus        `json:"releases"`
+	ControlPlanes []kubectl.ControlPlane `json:"controlPlanes"`
+	Peers         []kubectl.Peer         `json:"peers"`
 }
 
-func Apply(CRDPath string, kubeconfig *string, context string) {
-	clientConfig := map[string]string{
-		"kubeconfig": *kubeconfig,
-		"context":    context,
+// Status collect the helm releases, workload readiness, istio control planes and cluster-registry peers of a cluster
+func Status(releases []ReleaseRef, registryNamespace string, kubeconfig *string, context string) (ClusterStatus, error) {
+	clusterStatus := ClusterStatus{
+		Context:  context,
+		Releases: []ReleaseStatus{},
 	}
+	helmReleases := []*release.Release{}
 
-	err := kubectl.CreateClient(clientConfig)
+	for _, releaseRef := range releases {
+		releaseStatus := ReleaseStatus{
+			Name:      releaseRef.Name,
+			Namespace: releaseRef.Namespace,
+		}
+
+		helmRelease, err := GetHelmRelease(releaseRef.Name, releaseRef.Namespace, kubeconfig, context)
+		if err != nil {
+			return clusterStatus, err
+		}
+		if helmRelease == nil {
+			releaseStatus.Status = "not installed"
+			clusterStatus.Releases = append(clusterStatus.Releases, releaseStatus)
+			helmReleases = append(helmReleases, nil)
+			continue
+		}
+
+		releaseStatus.Chart = helmRelease.Chart.Metadata.Name
+		releaseStatus.Version = helmRelease.Chart.Metadata.Version
+		releaseStatus.Status = helmRelease.Info.Status.String()
+		releaseStatus.Revision = helmRelease.Version
+		releaseStatus.Description = helmRelease.Info.Description
+
+		clusterStatus.Releases = append(clusterStatus.Releases, releaseStatus)
+		helmReleases = append(helmReleases, helmRelease)
+	}
+
+	clientset, err := newClientset(kubeconfig, context)
 	if err != nil {
-		panic(err)
+		return clusterStatus, err
+	}
+
+	for i, helmRelease := range helmReleases {
+		if helmRelease == nil {
+			continue
+		}
+
+		workloads, err := releaseWorkloads(clientset, helmRelease)
+		if err != nil {
+			return clusterStatus, err
+		}
+
+		clusterStatus.Releases[i].Ready = true
+		for _, workload := range workloads {
+			readiness, err := clientset.WorkloadReadiness(workload)
+			if err != nil {
+				return clusterStatus, err
+			}
+
+			clusterStatus.Releases[i].Workloads = append(clusterStatus.Releases[i].Workloads, WorkloadStatus{Workload: workload, Ready: readiness.Ready, Reason: readiness.Reason})
+			clusterStatus.Releases[i].Ready = clusterStatus.Releases[i].Ready && readiness.Ready
+		}
 	}
 
-	CRObject, err := io.ReadYAMLResourceFile(CRDPath)
+	clusterStatus.ControlPlanes, err = clientset.GetControlPlanes()
 	if err != nil {
-		panic(err)
+		return clusterStatus, err
 	}
 
-	err = kubectl.Apply(CRObject)
+	clusterStatus.Peers, err = clientset.GetPeers(registryNamespace)
 	if err != nil {
-		panic(err)
+		return clusterStatus, err
 	}
 
-	kubectl.RemoveAllClients()
+	return clusterStatus, nil
 }
 
-func Remove(CRDPath string, kubeconfig *string, context string) {
-	clientConfig := map[string]string{
-		"kubeconfig": *kubeconfig,
-		"context":    context,
+// GetAPIServerEndpoint return the host of the API server of the cluster
+func GetAPIServerEndpoint(kubeconfig *string, context string) (string, error) {
+	clientset, err := newClientset(kubeconfig, context)
+	if err != nil {
+		return "", err
 	}
 
-	err := kubectl.CreateClient(clientConfig)
+	return clientset.GetAPIServerEndpoint()
+}
+
+// AppliedResource is an object of a custom resource file and what the apply did with it
+type AppliedResource struct {
+	Object *unstructured.Unstructured
+	Result kubectl.ApplyResult
+}
+
+// Apply create or update every object of the custom resource file on the cluster with server-side apply and return what is changed
+// The objects are applied in dependency order, when an object fails the already applied objects are returned with the error
+// ErrApplyConflict is returned when an other field manager own a changed field, with forceConflicts the fields are taken over
+func Apply(CRDPath string, forceConflicts bool, kubeconfig *string, context string) ([]AppliedResource, error) {
+	clientset, err := newClientset(kubeconfig, context)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	CRObject, err := io.ReadYAMLResourceFile(CRDPath)
+	objects, err := io.ReadYAMLResourceFile(CRDPath)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	err = kubectl.Remove(CRObject)
-	if err != nil {
-		panic(err)
+	applied, results, err := clientset.ApplyObjects(objects, forceConflicts)
+
+	resources := []AppliedResource{}
+	for i := range applied {
+		resources = append(resources, AppliedResource{Object: applied[i], Result: results[i]})
 	}
 
-	kubectl.RemoveAllClients()
+	return resources, err
 }
 
-func Attach(kubeconfig1 *string, context1 string, kubeconfig2 *string, context2 string, namespace1 string, namespace2 string) {
-	clientConfig1 := map[string]string{
-		"kubeconfig": *kubeconfig1,
-		"context":    context1,
+// ResourceNames return the kind and name of the objects in the custom resource file in apply order
+func ResourceNames(CRDPath string) ([]string, error) {
+	objects, err := io.ReadYAMLResourceFile(CRDPath)
+	if err != nil {
+		return nil, err
 	}
-	clientConfig2 := map[string]string{
-		"kubeconfig": *kubeconfig2,
-		"context":    context2,
+
+	names := []string{}
+	for _, object := range kubectl.ApplyOrder(objects) {
+		if object.GetNamespace() == "" {
+			names = append(names, fmt.Sprintf("%s %s", object.GetKind(), object.GetName()))
+			continue
+		}
+		names = append(names, fmt.Sprintf("%s %s/%s", object.GetKind(), object.GetNamespace(), object.GetName()))
 	}
 
-	err := kubectl.CreateClient(clientConfig1, clientConfig2)
+	return names, nil
+}
+
+// Remove delete exactly the objects of the custom resource file from the cluster in the reverse of the apply order
+// ErrStuckTerminating is returned when the options wait for the objects and they are not gone until the timeout
+func Remove(CRDPath string, options kubectl.RemoveOptions, kubeconfig *string, context string) error {
+	objects, err := io.ReadYAMLResourceFile(CRDPath)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = kubectl.Attach(namespace1, namespace2)
+
+	return RemoveObjects(objects, options, kubeconfig, context)
+}
+
+// RemoveObjects delete the objects from the cluster in the reverse of the apply order, e.g. the objects which are created by Apply
+func RemoveObjects(objects []*unstructured.Unstructured, options kubectl.RemoveOptions, kubeconfig *string, context string) error {
+	clientset, err := newClientset(kubeconfig, context)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	kubectl.RemoveAllClients()
+	return clientset.RemoveObjects(objects, options)
 }
 
-func Detach(kubeconfig1 *string, context1 string, kubeconfig2 *string, context2 string, namespace1 string, namespace2 string) {
-	clientConfig1 := map[string]string{
-		"kubeconfig": *kubeconfig1,
-		"context":    context1,
-	}
-	clientConfig2 := map[string]string{
-		"kubeconfig": *kubeconfig2,
-		"context":    context2,
+// MeshMember is a cluster which take part in the attach and detach process
+type MeshMember struct {
+	Kubeconfig *string
+	Context    string
+	// Name and Namespace of the cluster-registry Cluster and Secret objects of this cluster
+	Name      string
+	Namespace string
+}
+
+// Attach create or update the cluster-registry objects between every pair of the members, print a report per pair and a summary per cluster
+// A *MeshError is returned when one or more pair failed
+func Attach(members ...MeshMember) ([]kubectl.PairResult, error) {
+	return meshOperation("Attach", kubectl.Attach, members)
+}
+
+// Detach remove the cluster-registry objects of the peers from every member and print a report per pair
+// A *MeshError is returned when one or more pair failed
+func Detach(members ...MeshMember) ([]kubectl.PairResult, error) {
+	return meshOperation("Detach", kubectl.Detach, members)
+}
+
+func meshOperation(name string, operation func([]*kubectl.Clientset, []kubectl.ClusterIdentity) ([]kubectl.PairResult, error), members []MeshMember) ([]kubectl.PairResult, error) {
+	clientsets := []*kubectl.Clientset{}
+	identities := []kubectl.ClusterIdentity{}
+	for _, member := range members {
+		clientset, err := newClientset(member.Kubeconfig, member.Context)
+		if err != nil {
+			return nil, err
+		}
+
+		clientsets = append(clientsets, clientset)
+		identities = append(identities, kubectl.ClusterIdentity{Name: member.Name, Namespace: member.Namespace})
 	}
 
-	err := kubectl.CreateClient(clientConfig1, clientConfig2)
+	results, err := operation(clientsets, identities)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	err = kubectl.Detach(namespace1, namespace2)
-	if err != nil {
-		panic(err)
+
+	failed := false
+	fmt.Fprintf(out, "%s report:\n", name)
+	for _, result := range results {
+		if result.Err != nil {
+			failed = true
+			fmt.Fprintf(out, "  %s <-> %s: failed: %s\n", result.Source, result.Target, result.Err)
+		} else {
+			fmt.Fprintf(out, "  %s <-> %s: ok\n", result.Source, result.Target)
+		}
+	}
+
+	summaries := kubectl.Summarize(results)
+	if len(summaries) > 0 {
+		fmt.Fprintln(out, "Peer objects per cluster:")
+		for _, summary := range summaries {
+			fmt.Fprintf(out, "  %s: %d created, %d updated, %d unchanged\n", summary.Cluster, summary.Created, summary.Updated, summary.Unchanged)
+		}
+	}
+
+	if failed {
+		return results, &MeshError{Operation: name, Results: results}
 	}
 
-	kubectl.RemoveAllClients()
+	return results, nil
 }
@@ -0,0 +1,262 @@
+package kubereflex
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKubeconfigContent has a reachable context (kind-kind2 with the server of the test) and two unreachable contexts
+var testKubeconfigContent = `apiVersion: v1
+kind: Config
+clusters:
+  - name: kind
+    cluster:
+      server: https://127.0.0.1:1
+  - name: kind2
+    cluster:
+      server: %s
+      insecure-skip-tls-verify: true
+users:
+  - name: kind
+    user:
+      token: test
+  - name: kind2
+    user:
+      token: test
+contexts:
+  - name: kind-kind
+    context:
+      cluster: kind
+      user: kind
+  - name: kind-kind2
+    context:
+      cluster: kind2
+      user: kind2
+      namespace: istio-system
+  - name: kind-kind3
+    context:
+      cluster: kind
+      user: kind
+current-context: kind-kind2
+`
+
+// testPrompter select the contexts of the answers one by one, confirm with the confirms one by one and remember the offered choices
+type testPrompter struct {
+	answers  []string
+	confirms []bool
+	choices  []ContextChoice
+	labels   []string
+}
+
+func (p *testPrompter) SelectContext(_ string, choices []ContextChoice) (string, error) {
+	p.choices = choices
+	answer := p.answers[0]
+	p.answers = p.answers[1:]
+
+	return answer, nil
+}
+
+func (p *testPrompter) Confirm(label string) (bool, error) {
+	p.labels = append(p.labels, label)
+	confirm := p.confirms[0]
+	p.confirms = p.confirms[1:]
+
+	return confirm, nil
+}
+
+func writeTestKubeconfig(t *testing.T) *string {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"major": "1", "minor": "27", "gitVersion": "v1.27.1"}`)
+	}))
+	t.Cleanup(server.Close)
+
+	path := filepath.Join(t.TempDir(), "kubeconfig.yaml")
+	err := os.WriteFile(path, []byte(fmt.Sprintf(testKubeconfigContent, server.URL)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &path
+}
+
+func resetPrompt(t *testing.T, p Prompter) {
+	usedContexts = []string{}
+	SetPrompter(p)
+	pingTimeout = time.Second
+
+	t.Cleanup(func() {
+		usedContexts = []string{}
+		SetPrompter(nil)
+		pingTimeout = 2 * time.Second
+	})
+}
+
+func TestChooseContextFromConfig(t *testing.T) {
+	kubeconfig := writeTestKubeconfig(t)
+	p := &testPrompter{answers: []string{"kind-kind", "kind-kind2"}}
+	resetPrompt(t, p)
+
+	context, err := ChooseContextFromConfig(kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context != "kind-kind" {
+		t.Errorf("Expected kind-kind context, got %s", context)
+	}
+
+	// the current-context is the first, so it is the default choice
+	names := []string{}
+	for _, choice := range p.choices {
+		names = append(names, choice.Name)
+	}
+	if strings.Join(names, ",") != "kind-kind2,kind-kind,kind-kind3" {
+		t.Errorf("Expected every context in the picker with the current-context first, got %v", names)
+	}
+	if !p.choices[0].Reachable || p.choices[0].Server == "" || p.choices[0].User != "kind2" || p.choices[0].Namespace != "istio-system" {
+		t.Errorf("Expected the details of the reachable kind-kind2 context, got %+v", p.choices[0])
+	}
+	if p.choices[1].Reachable || p.choices[1].Server != "https://127.0.0.1:1" {
+		t.Errorf("Expected unreachable kind-kind context, got %+v", p.choices[1])
+	}
+
+	// the used context is offered, but marked
+	context, err = ChooseContextFromConfig(kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context != "kind-kind2" || !p.choices[1].Used || p.choices[0].Used {
+		t.Errorf("Expected kind-kind2 with used kind-kind, got %s from %+v", context, p.choices)
+	}
+	if p.choices[1].Status() != "unreachable, in use" {
+		t.Errorf("Expected unreachable and used kind-kind, got %s", p.choices[1].Status())
+	}
+
+	// the only unused context is returned without prompt
+	p.choices = nil
+	context, err = ChooseContextFromConfig(kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context != "kind-kind3" || p.choices != nil {
+		t.Errorf("Expected kind-kind3 without prompt, got %s from %v", context, p.choices)
+	}
+}
+
+func TestChooseContextFromConfigReuse(t *testing.T) {
+	kubeconfig := writeTestKubeconfig(t)
+	p := &testPrompter{answers: []string{"kind-kind", "kind-kind2"}, confirms: []bool{false, true}}
+	resetPrompt(t, p)
+	usedContexts = []string{"kind-kind", "kind-kind2", "kind-kind3"}
+
+	// the not confirmed context is asked again
+	context, err := ChooseContextFromConfig(kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context != "kind-kind2" {
+		t.Errorf("Expected the confirmed kind-kind2 context, got %s", context)
+	}
+	if len(p.labels) != 2 || !strings.Contains(p.labels[0], "kind-kind is already used") {
+		t.Errorf("Expected confirmation for every used context, got %v", p.labels)
+	}
+}
+
+func TestChooseContextFromConfigNonInteractive(t *testing.T) {
+	kubeconfig := writeTestKubeconfig(t)
+	resetPrompt(t, NonInteractivePrompter{})
+	usedContexts = []string{"kind-kind2"}
+
+	_, err := ChooseContextFromConfig(kubeconfig)
+	if !errors.Is(err, ErrNonInteractive) {
+		t.Fatalf("Expected ErrNonInteractive, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "kind-kind2 (in use), kind-kind, kind-kind3") {
+		t.Errorf("Expected the available contexts in the error, got %v", err)
+	}
+	if len(usedContexts) != 1 {
+		t.Errorf("Expected no new used context after the failure, got %v", usedContexts)
+	}
+
+	// the only unused context does not need a prompt
+	usedContexts = []string{"kind-kind", "kind-kind2"}
+	context, err := ChooseContextFromConfig(kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context != "kind-kind3" {
+		t.Errorf("Expected kind-kind3 context, got %s", context)
+	}
+}
+
+func TestChooseContextFromConfigNonInteractiveNoPing(t *testing.T) {
+	// the API server of the contexts never answer
+	done := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(done) })
+
+	path := filepath.Join(t.TempDir(), "kubeconfig.yaml")
+	content := strings.ReplaceAll(fmt.Sprintf(testKubeconfigContent, server.URL), "https://127.0.0.1:1", server.URL)
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resetPrompt(t, NonInteractivePrompter{})
+	pingTimeout = time.Minute
+
+	// the unused current-context is not selected either, the choice has to be given explicitly
+	start := time.Now()
+	_, err = ChooseContextFromConfig(&path)
+	if !errors.Is(err, ErrNonInteractive) {
+		t.Fatalf("Expected ErrNonInteractive, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Expected to fail without pinging the contexts, it took %s", time.Since(start))
+	}
+}
+
+func TestChooseContextFromConfigNoContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig.yaml")
+	err := os.WriteFile(path, []byte("apiVersion: v1\nkind: Config\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resetPrompt(t, NonInteractivePrompter{})
+
+	_, err = ChooseContextFromConfig(&path)
+	if !errors.Is(err, ErrContextNotFound) {
+		t.Errorf("Expected ErrContextNotFound without context, got %v", err)
+	}
+}
+
+func TestSetClusterOutput(t *testing.T) {
+	// two clusters of the topology with the same kubeconfig file and context
+	first, second := "kubeconfig.yaml", "kubeconfig.yaml"
+	var firstOutput, secondOutput strings.Builder
+
+	SetClusterOutput(&first, "kind-kind", &firstOutput)
+	SetClusterOutput(&second, "kind-kind", &secondOutput)
+	t.Cleanup(func() {
+		SetClusterOutput(&first, "kind-kind", nil)
+		SetClusterOutput(&second, "kind-kind", nil)
+	})
+
+	if clusterOutput(&first, "kind-kind") != &firstOutput || clusterOutput(&second, "kind-kind") != &secondOutput {
+		t.Errorf("Expected separate output for every cluster")
+	}
+
+	SetClusterOutput(&first, "kind-kind", nil)
+	if clusterOutput(&first, "kind-kind") != out || clusterOutput(&second, "kind-kind") != &secondOutput {
+		t.Errorf("Expected the default output only for the reset cluster")
+	}
+}
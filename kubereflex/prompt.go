@@ -0,0 +1,142 @@
+package kubereflex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/arpad-csepi/KLI/kubereflex/io"
+	"github.com/manifoldco/promptui"
+)
+
+// ContextChoice is a context of the kubeconfig in the context picker with its cluster details
+type ContextChoice struct {
+	io.Context
+	// Reachable tell the API server of the context answered a discovery request
+	Reachable bool
+	// Used tell the context is already selected for an other cluster
+	Used bool
+}
+
+// Status return the reachability of the context and whether it is already used, e.g. "reachable, in use"
+func (c ContextChoice) Status() string {
+	status := "unreachable"
+	if c.Reachable {
+		status = "reachable"
+	}
+	if c.Used {
+		status += ", in use"
+	}
+
+	return status
+}
+
+// Prompter ask the user which context is used for a cluster, ChooseContextFromConfig use it when more context can be selected
+type Prompter interface {
+	// SelectContext return the name of the selected context
+	SelectContext(label string, choices []ContextChoice) (string, error)
+	// Confirm return whether the user accepted the question
+	Confirm(label string) (bool, error)
+}
+
+// TerminalPrompter select the context with a searchable list on the terminal which show the server, user and reachability of the contexts
+// The prompts are written to the standard error, so they never mix with the output of the command, e.g. a JSON report
+type TerminalPrompter struct{}
+
+// contextTemplates show the name, server and status of a context in the list and every detail of the active context
+var contextTemplates = &promptui.SelectTemplates{
+	Label:    "{{ . }}",
+	Active:   "▸ {{ .Name | cyan }} {{ .Server | faint }} ({{ .Status }})",
+	Inactive: "  {{ .Name }} {{ .Server | faint }} ({{ .Status }})",
+	Selected: "✔ {{ .Name | green }}",
+	Details: `
+Cluster:   {{ .Cluster }}
+Server:    {{ .Server }}
+User:      {{ .User }}
+Namespace: {{ .Namespace }}
+Status:    {{ .Status }}`,
+}
+
+// SelectContext show the list of the contexts, the list can be searched by context, cluster, server and user
+// The cursor is on the first unused context
+func (TerminalPrompter) SelectContext(label string, choices []ContextChoice) (string, error) {
+	cursor := 0
+	for i, choice := range choices {
+		if !choice.Used {
+			cursor = i
+			break
+		}
+	}
+
+	prompt := promptui.Select{
+		Label:     label,
+		Items:     choices,
+		Templates: contextTemplates,
+		Size:      10,
+		CursorPos: cursor,
+		Stdout:    os.Stderr,
+		Searcher: func(input string, index int) bool {
+			choice := choices[index]
+			text := strings.ToLower(strings.Join([]string{choice.Name, choice.Cluster, choice.Server, choice.User}, " "))
+			return strings.Contains(text, strings.ToLower(strings.TrimSpace(input)))
+		},
+	}
+	index, _, err := prompt.Run()
+	if err != nil {
+		return "", err
+	}
+
+	return choices[index].Name, nil
+}
+
+// Confirm ask a yes or no question, the default is no
+func (TerminalPrompter) Confirm(label string) (bool, error) {
+	prompt := promptui.Prompt{
+		Label:     label,
+		IsConfirm: true,
+		Stdout:    os.Stderr,
+	}
+	_, err := prompt.Run()
+	if errors.Is(err, promptui.ErrAbort) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// NonInteractivePrompter never ask the user, it fail with the list of the contexts, so the missing choice can be given another way
+type NonInteractivePrompter struct{}
+
+// SelectContext return ErrNonInteractive with the contexts
+func (NonInteractivePrompter) SelectContext(label string, choices []ContextChoice) (string, error) {
+	names := []string{}
+	for _, choice := range choices {
+		name := choice.Name
+		if choice.Used {
+			name += " (in use)"
+		}
+		names = append(names, name)
+	}
+
+	return "", fmt.Errorf("%s: the prompt is disabled, choose one of %s: %w", label, strings.Join(names, ", "), ErrNonInteractive)
+}
+
+// Confirm return ErrNonInteractive, nothing is accepted without the user
+func (NonInteractivePrompter) Confirm(label string) (bool, error) {
+	return false, fmt.Errorf("%s: the prompt is disabled: %w", label, ErrNonInteractive)
+}
+
+// prompter is used by ChooseContextFromConfig, the default is the terminal
+var prompter Prompter = TerminalPrompter{}
+
+// SetPrompter set how the user select the context, nil restore the terminal prompt
+func SetPrompter(p Prompter) {
+	if p == nil {
+		p = TerminalPrompter{}
+	}
+	prompter = p
+}
@@ -0,0 +1,345 @@
+package topology
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Topology describes the clusters and the helm charts which are installed on every cluster
+type Topology struct {
+	Clusters []Cluster `json:"clusters"`
+	Charts   []Chart   `json:"charts"`
+	Attach   bool      `json:"attach,omitempty"`
+	// RegistryNamespace is the namespace of the cluster-registry Cluster and Secret objects
+	RegistryNamespace string `json:"registryNamespace,omitempty"`
+}
+
+// DefaultRegistryNamespace is used when the topology not set the registryNamespace
+const DefaultRegistryNamespace = "cluster-registry"
+
+// Cluster is one member of the topology
+type Cluster struct {
+	// Name is the cluster identity name which is used by the cluster-registry, the names have to be unique across the meshes which share a cluster
+	Name           string `json:"name"`
+	Kubeconfig     string `json:"kubeconfig,omitempty"`
+	Context        string `json:"context,omitempty"`
+	Network        string `json:"network"`
+	CustomResource string `json:"customResource,omitempty"`
+	// Releases contains extra helm values for this cluster, keyed by the release name
+	Releases map[string]ChartValues `json:"releases,omitempty"`
+}
+
+// Chart is a helm chart which is installed on every cluster of the topology
+type Chart struct {
+	URL        string `json:"url"`
+	Repository string `json:"repository"`
+	Name       string `json:"name"`
+	Release    string `json:"release"`
+	Namespace  string `json:"namespace"`
+	// Version is an exact chart version or a semver constraint, e.g. 2.17.1 or ~2.17.0, empty means the latest
+	Version string `json:"version,omitempty"`
+	// Devel allow the development (pre-release) chart versions
+	Devel bool `json:"devel,omitempty"`
+	ChartValues
+}
+
+// ChartValues are the helm values of a release, the set strings can refer to the cluster fields as template, e.g. {{ .Name }}
+type ChartValues struct {
+	// Values are the values files (-f) of the release
+	Values    []string `json:"values,omitempty"`
+	Set       string   `json:"set,omitempty"`
+	SetString string   `json:"setString,omitempty"`
+	SetFile   string   `json:"setFile,omitempty"`
+	SetJSON   string   `json:"setJSON,omitempty"`
+}
+
+// SetValues is the data which is available in the chart set templates
+type SetValues struct {
+	Name              string
+	Network           string
+	Context           string
+	APIServerEndpoint string
+}
+
+// Load read the topology file (YAML or JSON), resolve the relative paths next to the file and validate it
+func Load(path string) (*Topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	topology := &Topology{}
+	err = yaml.UnmarshalStrict(data, topology)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	topology.resolvePaths(filepath.Dir(path))
+
+	if topology.RegistryNamespace == "" {
+		topology.RegistryNamespace = DefaultRegistryNamespace
+	}
+
+	err = topology.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return topology, nil
+}
+
+// resolvePaths make the relative kubeconfig, custom resource, values and set file paths relative to the given directory
+func (t *Topology) resolvePaths(dir string) {
+	for i := range t.Clusters {
+		t.Clusters[i].Kubeconfig = resolvePath(dir, t.Clusters[i].Kubeconfig)
+		t.Clusters[i].CustomResource = resolvePath(dir, t.Clusters[i].CustomResource)
+
+		for release, chartValues := range t.Clusters[i].Releases {
+			chartValues.resolvePaths(dir)
+			t.Clusters[i].Releases[release] = chartValues
+		}
+	}
+
+	for i := range t.Charts {
+		t.Charts[i].ChartValues.resolvePaths(dir)
+	}
+}
+
+func (v *ChartValues) resolvePaths(dir string) {
+	for i := range v.Values {
+		if !isURL(v.Values[i]) {
+			v.Values[i] = resolvePath(dir, v.Values[i])
+		}
+	}
+
+	// setFile is key=path,... form, every path is resolved
+	if v.SetFile != "" {
+		pairs := strings.Split(v.SetFile, ",")
+		for i, pair := range pairs {
+			key, path, found := strings.Cut(pair, "=")
+			if found && !isURL(path) {
+				pairs[i] = key + "=" + resolvePath(dir, path)
+			}
+		}
+		v.SetFile = strings.Join(pairs, ",")
+	}
+}
+
+func resolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// isURL check the values file is a remote file which is downloaded by helm
+func isURL(path string) bool {
+	return strings.Contains(path, "://")
+}
+
+// Validate check the required fields and return with every problem prefixed by the field path
+func (t *Topology) Validate() error {
+	var errs []error
+
+	fieldError := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if len(t.Clusters) == 0 {
+		fieldError("clusters", "at least one cluster is required")
+	}
+
+	clusterNames := map[string]bool{}
+	for i, cluster := range t.Clusters {
+		field := fmt.Sprintf("clusters[%d]", i)
+
+		if cluster.Name == "" {
+			fieldError(field+".name", "must not be empty")
+		} else if clusterNames[cluster.Name] {
+			fieldError(field+".name", "duplicated cluster name %q", cluster.Name)
+		} else if messages := validation.IsDNS1123Subdomain(cluster.Name); len(messages) > 0 {
+			// the name is the name of the cluster-registry Cluster and Secret objects
+			fieldError(field+".name", "%s", strings.Join(messages, ", "))
+		}
+		clusterNames[cluster.Name] = true
+
+		if cluster.Network == "" {
+			fieldError(field+".network", "must not be empty")
+		}
+
+		if cluster.CustomResource != "" {
+			if _, err := os.Stat(cluster.CustomResource); err != nil {
+				fieldError(field+".customResource", "%s", err)
+			}
+		}
+	}
+
+	if len(t.Charts) == 0 {
+		fieldError("charts", "at least one chart is required")
+	}
+
+	releases := map[string]bool{}
+	for i, chart := range t.Charts {
+		field := fmt.Sprintf("charts[%d]", i)
+
+		if chart.URL == "" {
+			fieldError(field+".url", "must not be empty")
+		}
+		if chart.Repository == "" {
+			fieldError(field+".repository", "must not be empty")
+		}
+		if chart.Name == "" {
+			fieldError(field+".name", "must not be empty")
+		}
+		if chart.Namespace == "" {
+			fieldError(field+".namespace", "must not be empty")
+		}
+
+		if chart.Release == "" {
+			fieldError(field+".release", "must not be empty")
+		} else if releases[chart.Release] {
+			fieldError(field+".release", "duplicated release name %q", chart.Release)
+		}
+		releases[chart.Release] = true
+
+		if chart.Version != "" {
+			if _, err := semver.NewConstraint(chart.Version); err != nil {
+				fieldError(field+".version", "%s", err)
+			}
+		}
+
+		chart.ChartValues.validate(field, fieldError)
+	}
+
+	for i, cluster := range t.Clusters {
+		for release, chartValues := range cluster.Releases {
+			field := fmt.Sprintf("clusters[%d].releases.%s", i, release)
+			if !releases[release] {
+				fieldError(field, "no chart with this release name")
+			}
+
+			chartValues.validate(field, fieldError)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate check the values files exist and the set templates can be parsed
+func (v *ChartValues) validate(field string, fieldError func(field string, format string, args ...interface{})) {
+	for i, valuesFile := range v.Values {
+		if isURL(valuesFile) {
+			continue
+		}
+		if _, err := os.Stat(valuesFile); err != nil {
+			fieldError(fmt.Sprintf("%s.values[%d]", field, i), "%s", err)
+		}
+	}
+
+	for _, set := range v.setArguments() {
+		if _, err := template.New(set.field).Parse(set.value); err != nil {
+			fieldError(field+"."+set.field, "%s", err)
+		}
+	}
+}
+
+// setArgument is a set string with its topology field and helm argument name
+type setArgument struct {
+	field    string
+	argument string
+	value    string
+}
+
+// setArguments return the set strings in the helm precedence order
+func (v *ChartValues) setArguments() []setArgument {
+	return []setArgument{
+		{field: "setJSON", argument: "set-json", value: v.SetJSON},
+		{field: "set", argument: "set", value: v.Set},
+		{field: "setString", argument: "set-string", value: v.SetString},
+		{field: "setFile", argument: "set-file", value: v.SetFile},
+	}
+}
+
+// ArgumentsFor render the chart version and values for the cluster as helm install arguments and value levels
+// The value levels are the chart values and then the cluster specific values, helm merge them in order, so the cluster values take precedence
+func (c *Chart) ArgumentsFor(cluster *Cluster, apiServerEndpoint string) (map[string]string, []values.Options, error) {
+	data := SetValues{
+		Name:              cluster.Name,
+		Network:           cluster.Network,
+		Context:           cluster.Context,
+		APIServerEndpoint: apiServerEndpoint,
+	}
+
+	chartValues := []ChartValues{c.ChartValues}
+	if clusterValues, exists := cluster.Releases[c.Release]; exists {
+		chartValues = append(chartValues, clusterValues)
+	}
+
+	valueLevels := []values.Options{}
+	for _, chartValue := range chartValues {
+		options, err := chartValue.valueOptions(c.Release, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		valueLevels = append(valueLevels, options)
+	}
+
+	args := map[string]string{}
+	if c.Version != "" {
+		args["version"] = c.Version
+	}
+	if c.Devel {
+		args["devel"] = "true"
+	}
+
+	return args, valueLevels, nil
+}
+
+// valueOptions render the set strings for the cluster and return them with the values files as helm value options
+func (v *ChartValues) valueOptions(release string, data SetValues) (values.Options, error) {
+	options := values.Options{ValueFiles: append([]string{}, v.Values...)}
+	targets := map[string]*[]string{
+		"set-json":   &options.JSONValues,
+		"set":        &options.Values,
+		"set-string": &options.StringValues,
+		"set-file":   &options.FileValues,
+	}
+
+	for _, set := range v.setArguments() {
+		rendered, err := renderSet(release+" "+set.field, set.value, data)
+		if err != nil {
+			return values.Options{}, err
+		}
+		if rendered != "" {
+			*targets[set.argument] = append(*targets[set.argument], rendered)
+		}
+	}
+
+	return options, nil
+}
+
+// renderSet execute the set template with the cluster data
+func renderSet(name string, set string, data SetValues) (string, error) {
+	setTemplate, err := template.New(name).Option("missingkey=error").Parse(set)
+	if err != nil {
+		return "", err
+	}
+
+	var rendered bytes.Buffer
+	err = setTemplate.Execute(&rendered, data)
+	if err != nil {
+		return "", err
+	}
+
+	return rendered.String(), nil
+}
@@ -0,0 +1,252 @@
+package topology
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arpad-csepi/KLI/kubereflex/helm"
+	"helm.sh/helm/v3/pkg/cli/values"
+)
+
+var testTopologyContent = `clusters:
+  - name: demo-active
+    kubeconfig: kubeconfig.yaml
+    context: kind-kind
+    network: network1
+    releases:
+      cluster-registry:
+        values: [active-values.yaml]
+        set: "replicas=2"
+        setString: "image.tag=1.0"
+  - name: demo-passive
+    context: kind-kind2
+    network: network2
+charts:
+  - url: https://cisco-open.github.io/cluster-registry-controller
+    repository: cluster-registry
+    name: cluster-registry
+    release: cluster-registry
+    namespace: cluster-registry
+    version: "~0.2.10"
+    devel: true
+    values: [https://example.com/values.yaml, values.yaml]
+    setJSON: 'resources={"limits":{"cpu":"100m"}}'
+    setFile: "license=license.txt,ca=/etc/ssl/ca.pem"
+    set: "localCluster.name={{ .Name }},network.name={{ .Network }},controller.apiServerEndpointAddress={{ .APIServerEndpoint }}"
+attach: true
+`
+
+func writeTestTopology(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	return path
+}
+
+func writeTestValues(t *testing.T, dir string) {
+	for _, name := range []string{"values.yaml", "active-values.yaml"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("replicas: 1\n"), 0644)
+		if err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeTestTopology(t, "topology.yaml", testTopologyContent)
+	writeTestValues(t, filepath.Dir(path))
+
+	topology, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(topology.Clusters) != 2 || len(topology.Charts) != 1 || !topology.Attach {
+		t.Error("Topology not properly loaded")
+	}
+
+	if topology.Clusters[0].Kubeconfig != filepath.Join(filepath.Dir(path), "kubeconfig.yaml") {
+		t.Errorf("Relative kubeconfig path is not resolved: %s", topology.Clusters[0].Kubeconfig)
+	}
+
+	if topology.Charts[0].Values[0] != "https://example.com/values.yaml" || topology.Charts[0].Values[1] != filepath.Join(filepath.Dir(path), "values.yaml") {
+		t.Errorf("Values files are not resolved: %v", topology.Charts[0].Values)
+	}
+
+	expectedSetFile := "license=" + filepath.Join(filepath.Dir(path), "license.txt") + ",ca=/etc/ssl/ca.pem"
+	if topology.Charts[0].SetFile != expectedSetFile {
+		t.Errorf("Set file paths are not resolved: %s", topology.Charts[0].SetFile)
+	}
+
+	if topology.RegistryNamespace != DefaultRegistryNamespace {
+		t.Errorf("Registry namespace should be defaulted")
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	content := `{"clusters": [{"name": "demo", "network": "network1"}],
+		"charts": [{"url": "https://example.com", "repository": "repo", "name": "chart", "release": "release", "namespace": "default"}]}`
+	path := writeTestTopology(t, "topology.json", content)
+
+	topology, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if topology.Clusters[0].Name != "demo" || topology.Charts[0].Release != "release" {
+		t.Error("Topology not properly loaded")
+	}
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	content := strings.Replace(testTopologyContent, "network: network2", "netwrok: network2", 1)
+	path := writeTestTopology(t, "topology.yaml", content)
+	writeTestValues(t, filepath.Dir(path))
+
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "netwrok") {
+		t.Errorf("Unknown field should be reported: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	topology := Topology{
+		Clusters: []Cluster{
+			{Name: "demo", Network: "network1"},
+			{Name: "demo", Releases: map[string]ChartValues{"not-a-release": {Set: "a=b"}, "release": {Values: []string{"missing.yaml"}}}},
+			{Name: "Demo_3", Network: "network3"},
+		},
+		Charts: []Chart{
+			{URL: "https://example.com", Repository: "repo", Name: "chart", Release: "release", Namespace: "default", Version: "not-a-version", ChartValues: ChartValues{Set: "{{ .Name", SetJSON: "{{"}},
+			{Repository: "repo", Name: "chart", Release: "release", Namespace: "default"},
+		},
+	}
+
+	err := topology.Validate()
+	if err == nil {
+		t.Fatal("Invalid topology should not pass validation")
+	}
+
+	expectedFields := []string{
+		"clusters[1].name",
+		"clusters[1].network",
+		"clusters[1].releases.not-a-release",
+		"clusters[1].releases.release.values[0]",
+		"clusters[2].name",
+		"charts[0].version",
+		"charts[0].set",
+		"charts[0].setJSON",
+		"charts[1].url",
+		"charts[1].release",
+	}
+	for _, field := range expectedFields {
+		if !strings.Contains(err.Error(), field+":") {
+			t.Errorf("Validation error should point at %s, got: %s", field, err)
+		}
+	}
+}
+
+func TestArgumentsFor(t *testing.T) {
+	path := writeTestTopology(t, "topology.yaml", testTopologyContent)
+	writeTestValues(t, filepath.Dir(path))
+
+	topology, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, valueLevels, err := topology.Charts[0].ArgumentsFor(&topology.Clusters[0], "127.0.0.1:6443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if args["version"] != "~0.2.10" || args["devel"] != "true" {
+		t.Errorf("Wrong version arguments: %v", args)
+	}
+
+	if len(valueLevels) != 2 {
+		t.Fatalf("Chart and cluster values should be separate levels: %v", valueLevels)
+	}
+	chartLevel, clusterLevel := valueLevels[0], valueLevels[1]
+	if strings.Join(chartLevel.ValueFiles, "|") != "https://example.com/values.yaml|"+filepath.Join(filepath.Dir(path), "values.yaml") {
+		t.Errorf("Wrong chart values files: %v", chartLevel.ValueFiles)
+	}
+	if strings.Join(chartLevel.Values, "|") != "localCluster.name=demo-active,network.name=network1,controller.apiServerEndpointAddress=127.0.0.1:6443" {
+		t.Errorf("Wrong chart set values: %v", chartLevel.Values)
+	}
+	if strings.Join(chartLevel.JSONValues, "|") != `resources={"limits":{"cpu":"100m"}}` {
+		t.Errorf("Wrong chart JSON values: %v", chartLevel.JSONValues)
+	}
+	if strings.Join(clusterLevel.ValueFiles, "|") != filepath.Join(filepath.Dir(path), "active-values.yaml") ||
+		strings.Join(clusterLevel.Values, "|") != "replicas=2" || strings.Join(clusterLevel.StringValues, "|") != "image.tag=1.0" {
+		t.Errorf("Wrong cluster values: %+v", clusterLevel)
+	}
+
+	_, valueLevels, err = topology.Charts[0].ArgumentsFor(&topology.Clusters[1], "127.0.0.1:6444")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(valueLevels) != 1 {
+		t.Errorf("Cluster specific values leaked to another cluster: %v", valueLevels)
+	}
+}
+
+func TestValuePrecedence(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"chart-values.yaml":   "replicas: 0\nchartOnly: true\n",
+		"cluster-values.yaml": "replicas: 2\nimage:\n  tag: cluster\n",
+		"flag-values.yaml":    "replicas: 3\n",
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cluster := Cluster{
+		Name:     "demo-active",
+		Network:  "network1",
+		Releases: map[string]ChartValues{"cluster-registry": {Values: []string{filepath.Join(dir, "cluster-values.yaml")}}},
+	}
+	chart := Chart{
+		Release: "cluster-registry",
+		ChartValues: ChartValues{
+			Values:    []string{filepath.Join(dir, "chart-values.yaml")},
+			Set:       "replicas=1",
+			SetString: "image.tag=chart",
+		},
+	}
+
+	_, valueLevels, err := chart.ArgumentsFor(&cluster, "127.0.0.1:6443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the values file of the cluster override the set values of the chart
+	vals, err := helm.MergeValues(valueLevels, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals["replicas"] != float64(2) || vals["image"].(map[string]interface{})["tag"] != "cluster" || vals["chartOnly"] != true {
+		t.Errorf("Cluster values should override the chart values: %v", vals)
+	}
+
+	// the values file of the flags override the cluster values
+	valueLevels = append(valueLevels, values.Options{ValueFiles: []string{filepath.Join(dir, "flag-values.yaml")}})
+	vals, err = helm.MergeValues(valueLevels, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals["replicas"] != float64(3) {
+		t.Errorf("Flag values should override the topology values: %v", vals)
+	}
+}